@@ -3,31 +3,90 @@ package args
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"path"
+	"regexp"
+	"strings"
 
 	sdkArgs "github.com/newrelic/infra-integrations-sdk/v3/args"
 	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/obfuscation"
+)
+
+// Supported authentication methods
+const (
+	AuthenticationSQL      = "sql"
+	AuthenticationNTLM     = "ntlm"
+	AuthenticationKerberos = "kerberos"
+
+	AuthenticationAzureServicePrincipal = "azure_service_principal"
+	AuthenticationAzureManagedIdentity  = "azure_managed_identity"
+	AuthenticationAzureAccessToken      = "azure_access_token"
+)
+
+// Sources of the deadlock reports of the system_health session
+const (
+	DeadlockSourceFile       = "file"
+	DeadlockSourceRingBuffer = "ring_buffer"
 )
 
 // ArgumentList struct that holds all MSSQL arguments
 type ArgumentList struct {
 	sdkArgs.DefaultArgumentList
-	Username                     string `default:"" help:"The Microsoft SQL Server connection user name"`
-	Password                     string `default:"" help:"The Microsoft SQL Server connection password"`
-	Instance                     string `default:"" help:"The Microsoft SQL Server instance to connect to"`
-	Hostname                     string `default:"127.0.0.1" help:"The Microsoft SQL Server connection host name"`
-	Port                         string `default:"" help:"The Microsoft SQL Server port to connect to. Only needed when instance not specified"`
-	EnableSSL                    bool   `default:"false" help:"If true will use SSL encryption, false will not use encryption"`
-	TrustServerCertificate       bool   `default:"false" help:"If true server certificate is not verified for SSL. If false certificate will be verified against supplied certificate"`
-	CertificateLocation          string `default:"" help:"Certificate file to verify SSL encryption against"`
-	EnableBufferMetrics          bool   `default:"true" help:"Enable collection of buffer space metrics."`
-	EnableDatabaseReserveMetrics bool   `default:"true" help:"Enable collection of database reserve space metrics."`
-	Timeout                      string `default:"30" help:"Timeout in seconds for a single SQL Query. Set 0 for no timeout"`
-	CustomMetricsQuery           string `default:"" help:"A SQL query to collect custom metrics. Query results 'metric_name', 'metric_value', and 'metric_type' have special meanings"`
-	CustomMetricsConfig          string `default:"" help:"YAML configuration with one or more SQL queries to collect custom metrics"`
-	ShowVersion                  bool   `default:"false" help:"Print build information and exit"`
-	ExtraConnectionURLArgs       string `default:"" help:"Appends additional parameters to connection url. Ex. 'applicationintent=readonly&foo=bar'"`
-	EnableDiskMetricsInBytes     bool   `default:"true" help:"Enable collection of instance.diskInBytes."`
+	Username                       string `default:"" help:"The Microsoft SQL Server connection user name. Use DOMAIN\\user for NTLM and user@REALM for Kerberos authentication"`
+	Password                       string `default:"" help:"The Microsoft SQL Server connection password"`
+	Instance                       string `default:"" help:"The Microsoft SQL Server instance to connect to"`
+	Hostname                       string `default:"127.0.0.1" help:"The Microsoft SQL Server connection host name"`
+	Port                           string `default:"" help:"The Microsoft SQL Server port to connect to. Only needed when instance not specified"`
+	EnableSSL                      bool   `default:"false" help:"If true will use SSL encryption, false will not use encryption"`
+	TrustServerCertificate         bool   `default:"false" help:"If true server certificate is not verified for SSL. If false certificate will be verified against supplied certificate"`
+	CertificateLocation            string `default:"" help:"Certificate file to verify SSL encryption against"`
+	EnableBufferMetrics            bool   `default:"true" help:"Enable collection of buffer space metrics."`
+	EnableDatabaseReserveMetrics   bool   `default:"true" help:"Enable collection of database reserve space metrics."`
+	Timeout                        string `default:"30" help:"Timeout in seconds for connecting to the server. Set 0 for no timeout"`
+	CustomMetricsQuery             string `default:"" help:"A SQL query to collect custom metrics. Query results 'metric_name', 'metric_value', and 'metric_type' have special meanings"`
+	CustomMetricsConfig            string `default:"" help:"YAML configuration with one or more SQL queries to collect custom metrics"`
+	ShowVersion                    bool   `default:"false" help:"Print build information and exit"`
+	ExtraConnectionURLArgs         string `default:"" help:"Appends additional parameters to connection url. Ex. 'applicationintent=readonly&foo=bar'"`
+	EnableDiskMetricsInBytes       bool   `default:"true" help:"Enable collection of instance.diskInBytes."`
+	DatabaseInclude                string `default:"" help:"Comma separated list of database name patterns to monitor. Globs ('*', '?') or regular expressions enclosed in slashes, case insensitive. All databases are monitored if empty"`
+	DatabaseExclude                string `default:"" help:"Comma separated list of database name patterns to exclude from monitoring. Globs ('*', '?') or regular expressions enclosed in slashes, case insensitive"`
+	IncludeSystemDatabases         bool   `default:"false" help:"Enable monitoring of the system databases master, msdb, tempdb and model"`
+	Authentication                 string `default:"sql" help:"Authentication method: 'sql' for SQL Server logins, 'ntlm' or 'kerberos' for Windows authentication, 'azure_service_principal', 'azure_managed_identity' or 'azure_access_token' for Azure AD (Entra ID) authentication"`
+	KerberosConfigFile             string `default:"" help:"Kerberos configuration file (krb5.conf). Defaults to the KRB5_CONFIG environment variable or /etc/krb5.conf"`
+	KerberosKeytabFile             string `default:"" help:"Kerberos keytab file used to authenticate the user name without a password"`
+	KerberosCredentialCacheFile    string `default:"" help:"Kerberos credential cache file used to authenticate without user name and password"`
+	KerberosRealm                  string `default:"" help:"Kerberos realm. Defaults to the realm in the user name or the default realm in the Kerberos configuration"`
+	ServerSPN                      string `default:"" help:"Service Principal Name of the SQL Server used for Kerberos authentication. Ex: MSSQLSvc/host.domain.com:1433"`
+	AzureClientID                  string `default:"" help:"Azure AD application (client) ID of the service principal, or client ID of a user-assigned managed identity"`
+	AzureTenantID                  string `default:"" help:"Azure AD tenant ID of the service principal. Defaults to the tenant of the server"`
+	AzureClientSecret              string `default:"" help:"Azure AD client secret of the service principal"`
+	AzureClientCertificate         string `default:"" help:"PEM or PKCS#12 certificate file with the private key of the service principal, used instead of the client secret"`
+	AzureClientCertificatePassword string `default:"" help:"Password of the service principal certificate file, if encrypted"`
+	AzureAccessTokenFile           string `default:"" help:"File containing an Azure AD access token for the database resource. It is read on every login so it can be refreshed externally"`
+	TargetsConfig                  string `default:"" help:"YAML file listing multiple SQL Server instances to collect in a single run. Each target overrides any of the arguments"`
+	MaxConcurrentTargets           int    `default:"4" help:"Maximum number of targets from targets_config collected concurrently"`
+	MaxConcurrentDatabaseQueries   int    `default:"4" help:"Maximum number of databases queried concurrently for per-database metrics. Also sets the size of the connection pool"`
+	QueryTimeout                   int    `default:"0" help:"Timeout in seconds for a single SQL query run by the integration. Set 0 for no timeout"`
+	CollectionTimeout              int    `default:"0" help:"Maximum time in seconds for the whole collection. Remaining queries are cancelled when reached and the data already collected is reported. Set 0 for no limit"`
+	EnableIntegrationTelemetry     bool   `default:"true" help:"Enable reporting MssqlIntegrationSample with the duration, rows and errors of every query run by the integration"`
+	EnableAvailabilityGroupMetrics bool   `default:"true" help:"Enable collection of Always On availability group and replica metrics"`
+	EnableAgentJobMetrics          bool   `default:"false" help:"Enable collection of SQL Server Agent job metrics. Requires read access to the job tables in msdb"`
+	EnableDatabaseBackupMetrics    bool   `default:"true" help:"Enable collection of the age, size and duration of the last backups of each database. Requires read access to the backup history in msdb"`
+	EnableQueryMetrics             bool   `default:"false" help:"Enable reporting MssqlQuerySample for the top queries of the plan cache since the previous collection"`
+	QueryMetricsTopN               int    `default:"10" help:"Number of queries reported for each of the CPU, duration, reads and executions rankings"`
+	QueryTextMode                  string `default:"obfuscate" help:"How the SQL text collected is reported: off (as is), obfuscate (literals replaced with ?), drop_text (not reported) or hash_only (hash of the obfuscated text)"`
+	EnableBlockingMetrics          bool   `default:"true" help:"Enable reporting MssqlBlockingChainSample for every chain of blocked sessions, with its head blocker"`
+	EnableDeadlockMetrics          bool   `default:"false" help:"Enable reporting the deadlocks captured by the system_health session since the previous collection"`
+	DeadlockSource                 string `default:"file" help:"Target of the system_health session the deadlocks are read from: file or ring_buffer"`
+	WaitStatsTopN                  int    `default:"20" help:"Number of wait types reported, the ones with the most wait time since the previous collection. Set 0 to report all"`
+	WaitStatsExclude               string `default:"" help:"Comma separated wait types not reported, in addition to the benign waits. Globs ('*', '?') are supported, Ex: 'PREEMPTIVE_*, BACKUPIO'"`
+	IncludeBenignWaits             bool   `default:"false" help:"Report the idle and background waits excluded by default"`
+	EnableErrorLog                 bool   `default:"false" help:"Enable reporting the entries written to the SQL Server error log since the previous collection as events"`
+	ErrorLogMinSeverity            int    `default:"0" help:"Minimum severity of the error log entries reported, the entries without an error have severity 0"`
+	ErrorLogInclude                string `default:"" help:"Regular expression the message of the error log entries reported must match"`
+	ErrorLogExclude                string `default:"" help:"Regular expression matching the message of the error log entries not reported, Ex: '^Login succeeded'"`
 }
 
 // Validate validates SQL specific arguments
@@ -48,6 +107,71 @@ func (al ArgumentList) Validate() error {
 		return errors.New("invalid configuration: must specify a certificate file when using SSL and not trusting server certificate")
 	}
 
+	if err := al.validateAuthentication(); err != nil {
+		return err
+	}
+
+	for argName, patterns := range map[string]string{"database_include": al.DatabaseInclude, "database_exclude": al.DatabaseExclude} {
+		if _, err := CompileNamePatterns(patterns); err != nil {
+			return fmt.Errorf("invalid configuration: %s pattern: %w", argName, err)
+		}
+	}
+
+	if al.QueryTimeout < 0 {
+		return errors.New("invalid configuration: query_timeout cannot be negative")
+	}
+
+	if al.CollectionTimeout < 0 {
+		return errors.New("invalid configuration: collection_timeout cannot be negative")
+	}
+
+	if al.MaxConcurrentDatabaseQueries < 1 {
+		return errors.New("invalid configuration: max_concurrent_database_queries must be greater than 0")
+	}
+
+	if al.EnableQueryMetrics && al.QueryMetricsTopN < 1 {
+		return errors.New("invalid configuration: query_metrics_top_n must be greater than 0")
+	}
+
+	if _, err := obfuscation.New(al.QueryTextMode); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	if al.WaitStatsTopN < 0 {
+		return errors.New("invalid configuration: wait_stats_top_n cannot be negative")
+	}
+
+	for _, pattern := range al.ExcludedWaitTypes() {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid configuration: wait_stats_exclude pattern '%s': %w", pattern, err)
+		}
+	}
+
+	if al.ErrorLogMinSeverity < 0 || al.ErrorLogMinSeverity > 25 {
+		return errors.New("invalid configuration: error_log_min_severity must be between 0 and 25")
+	}
+
+	for argName, expr := range map[string]string{"error_log_include": al.ErrorLogInclude, "error_log_exclude": al.ErrorLogExclude} {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("invalid configuration: %s: %w", argName, err)
+		}
+	}
+
+	switch al.DeadlockSource {
+	case "", DeadlockSourceFile, DeadlockSourceRingBuffer:
+	default:
+		return fmt.Errorf("invalid configuration: deadlock_source must be %s or %s", DeadlockSourceFile, DeadlockSourceRingBuffer)
+	}
+
+	if len(al.TargetsConfig) > 0 {
+		if _, err := os.Stat(al.TargetsConfig); err != nil {
+			return errors.New("targets_config argument: " + err.Error())
+		}
+		if al.MaxConcurrentTargets < 1 {
+			return errors.New("invalid configuration: max_concurrent_targets must be greater than 0")
+		}
+	}
+
 	if len(al.CustomMetricsConfig) > 0 {
 		if len(al.CustomMetricsQuery) > 0 {
 			return errors.New("cannot specify options custom_metrics_query and custom_metrics_config")
@@ -59,3 +183,89 @@ func (al ArgumentList) Validate() error {
 
 	return nil
 }
+
+// ExcludedWaitTypes returns the wait types of wait_stats_exclude, in upper case as SQL Server names them
+func (al ArgumentList) ExcludedWaitTypes() []string {
+	waitTypes := make([]string, 0)
+	for _, waitType := range strings.Split(al.WaitStatsExclude, ",") {
+		if waitType = strings.ToUpper(strings.TrimSpace(waitType)); waitType != "" {
+			waitTypes = append(waitTypes, waitType)
+		}
+	}
+	return waitTypes
+}
+
+// IsAzureADAuthentication returns true if the authentication method uses Azure AD (Entra ID) tokens
+func (al ArgumentList) IsAzureADAuthentication() bool {
+	switch al.Authentication {
+	case AuthenticationAzureServicePrincipal, AuthenticationAzureManagedIdentity, AuthenticationAzureAccessToken:
+		return true
+	default:
+		return false
+	}
+}
+
+// validateAuthentication validates the arguments required by the authentication method
+func (al ArgumentList) validateAuthentication() error {
+	switch al.Authentication {
+	case "", AuthenticationSQL:
+		return nil
+	case AuthenticationNTLM:
+		if !strings.Contains(al.Username, `\`) || al.Password == "" {
+			return errors.New("invalid configuration: ntlm authentication requires a username in the form DOMAIN\\user and a password")
+		}
+		return nil
+	case AuthenticationKerberos:
+		return al.validateKerberos()
+	case AuthenticationAzureServicePrincipal:
+		if al.AzureClientID == "" {
+			return errors.New("invalid configuration: azure_service_principal authentication requires azure_client_id")
+		}
+		if al.AzureClientCertificate != "" {
+			if _, err := os.Stat(al.AzureClientCertificate); err != nil {
+				return errors.New("azure_client_certificate argument: " + err.Error())
+			}
+		} else if al.AzureClientSecret == "" {
+			return errors.New("invalid configuration: azure_service_principal authentication requires azure_client_secret or azure_client_certificate")
+		}
+		return nil
+	case AuthenticationAzureManagedIdentity:
+		return nil
+	case AuthenticationAzureAccessToken:
+		if _, err := os.Stat(al.AzureAccessTokenFile); err != nil {
+			return errors.New("azure_access_token_file argument: " + err.Error())
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid configuration: unknown authentication method '%s'", al.Authentication)
+	}
+}
+
+func (al ArgumentList) validateKerberos() error {
+	if al.KerberosKeytabFile != "" && al.Username == "" {
+		return errors.New("invalid configuration: kerberos authentication with a keytab file requires a username")
+	}
+
+	if al.KerberosKeytabFile == "" && al.KerberosCredentialCacheFile == "" && (al.Username == "" || al.Password == "") {
+		return errors.New("invalid configuration: kerberos authentication requires a username and password, a keytab file or a credential cache file")
+	}
+
+	files := []struct {
+		argName string
+		path    string
+	}{
+		{"kerberos_config_file", al.KerberosConfigFile},
+		{"kerberos_keytab_file", al.KerberosKeytabFile},
+		{"kerberos_credential_cache_file", al.KerberosCredentialCacheFile},
+	}
+	for _, file := range files {
+		if file.path == "" {
+			continue
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			return errors.New(file.argName + " argument: " + err.Error())
+		}
+	}
+
+	return nil
+}
@@ -1,6 +1,8 @@
 package args
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -13,18 +15,20 @@ func TestValidate(t *testing.T) {
 		{
 			"No Errors",
 			&ArgumentList{
-				Username: "user",
-				Hostname: "localhost",
-				Port:     "90",
+				MaxConcurrentDatabaseQueries: 4,
+				Username:                     "user",
+				Hostname:                     "localhost",
+				Port:                         "90",
 			},
 			false,
 		},
 		{
 			"No Username",
 			&ArgumentList{
-				Username: "",
-				Hostname: "localhost",
-				Port:     "90",
+				MaxConcurrentDatabaseQueries: 4,
+				Username:                     "",
+				Hostname:                     "localhost",
+				Port:                         "90",
 			},
 			false,
 		},
@@ -40,8 +44,9 @@ func TestValidate(t *testing.T) {
 		{
 			"No Port or Instance",
 			&ArgumentList{
-				Username: "user",
-				Hostname: "localhost",
+				MaxConcurrentDatabaseQueries: 4,
+				Username:                     "user",
+				Hostname:                     "localhost",
 			},
 			false,
 		},
@@ -55,6 +60,74 @@ func TestValidate(t *testing.T) {
 			},
 			true,
 		},
+		{
+			"Missing Targets Config",
+			&ArgumentList{
+				Hostname:             "localhost",
+				TargetsConfig:        "missing-targets.yml",
+				MaxConcurrentTargets: 4,
+			},
+			true,
+		},
+		{
+			"Unknown Query Text Mode",
+			&ArgumentList{
+				Hostname:      "localhost",
+				QueryTextMode: "redact",
+			},
+			true,
+		},
+		{
+			"Negative Query Timeout",
+			&ArgumentList{
+				Hostname:                     "localhost",
+				MaxConcurrentDatabaseQueries: 4,
+				QueryTimeout:                 -1,
+			},
+			true,
+		},
+		{
+			"No Concurrent Database Queries",
+			&ArgumentList{
+				Hostname:                     "localhost",
+				MaxConcurrentDatabaseQueries: 0,
+			},
+			true,
+		},
+		{
+			"No Top Queries",
+			&ArgumentList{
+				Hostname:                     "localhost",
+				MaxConcurrentDatabaseQueries: 4,
+				EnableQueryMetrics:           true,
+				QueryMetricsTopN:             -1,
+			},
+			true,
+		},
+		{
+			"Invalid Database Include Pattern",
+			&ArgumentList{
+				Hostname:        "localhost",
+				DatabaseInclude: "tenant_*, /^archive_[0-9/",
+			},
+			true,
+		},
+		{
+			"Invalid Wait Stats Exclude Pattern",
+			&ArgumentList{
+				Hostname:         "localhost",
+				WaitStatsExclude: "PREEMPTIVE_*, LCK_[",
+			},
+			true,
+		},
+		{
+			"Invalid Error Log Exclude",
+			&ArgumentList{
+				Hostname:        "localhost",
+				ErrorLogExclude: "^Login (succeeded",
+			},
+			true,
+		},
 		{
 			"SSL and No Server Certificate",
 			&ArgumentList{
@@ -78,3 +151,106 @@ func TestValidate(t *testing.T) {
 		}
 	}
 }
+
+func TestValidate_Authentication(t *testing.T) {
+	keytab := filepath.Join(t.TempDir(), "user.keytab")
+	if err := os.WriteFile(keytab, []byte{}, 0600); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	testCases := []struct {
+		name      string
+		arg       *ArgumentList
+		wantError bool
+	}{
+		{
+			"Unknown Method",
+			&ArgumentList{MaxConcurrentDatabaseQueries: 4, Hostname: "localhost", Authentication: "password"},
+			true,
+		},
+		{
+			"NTLM",
+			&ArgumentList{MaxConcurrentDatabaseQueries: 4, Hostname: "localhost", Authentication: AuthenticationNTLM, Username: `DOMAIN\user`, Password: "pass"},
+			false,
+		},
+		{
+			"NTLM No Domain",
+			&ArgumentList{MaxConcurrentDatabaseQueries: 4, Hostname: "localhost", Authentication: AuthenticationNTLM, Username: "user", Password: "pass"},
+			true,
+		},
+		{
+			"NTLM No Password",
+			&ArgumentList{MaxConcurrentDatabaseQueries: 4, Hostname: "localhost", Authentication: AuthenticationNTLM, Username: `DOMAIN\user`},
+			true,
+		},
+		{
+			"Kerberos Password",
+			&ArgumentList{MaxConcurrentDatabaseQueries: 4, Hostname: "localhost", Authentication: AuthenticationKerberos, Username: "user@REALM", Password: "pass"},
+			false,
+		},
+		{
+			"Kerberos Keytab",
+			&ArgumentList{MaxConcurrentDatabaseQueries: 4, Hostname: "localhost", Authentication: AuthenticationKerberos, Username: "user@REALM", KerberosKeytabFile: keytab},
+			false,
+		},
+		{
+			"Kerberos Keytab No Username",
+			&ArgumentList{MaxConcurrentDatabaseQueries: 4, Hostname: "localhost", Authentication: AuthenticationKerberos, KerberosKeytabFile: keytab},
+			true,
+		},
+		{
+			"Kerberos Missing Keytab",
+			&ArgumentList{MaxConcurrentDatabaseQueries: 4, Hostname: "localhost", Authentication: AuthenticationKerberos, Username: "user@REALM", KerberosKeytabFile: keytab + ".missing"},
+			true,
+		},
+		{
+			"Azure Service Principal Secret",
+			&ArgumentList{MaxConcurrentDatabaseQueries: 4, Hostname: "db.database.windows.net", Authentication: AuthenticationAzureServicePrincipal, AzureClientID: "client", AzureClientSecret: "secret"},
+			false,
+		},
+		{
+			"Azure Service Principal No Client ID",
+			&ArgumentList{MaxConcurrentDatabaseQueries: 4, Hostname: "db.database.windows.net", Authentication: AuthenticationAzureServicePrincipal, AzureClientSecret: "secret"},
+			true,
+		},
+		{
+			"Azure Service Principal No Credential",
+			&ArgumentList{MaxConcurrentDatabaseQueries: 4, Hostname: "db.database.windows.net", Authentication: AuthenticationAzureServicePrincipal, AzureClientID: "client"},
+			true,
+		},
+		{
+			"Azure Service Principal Missing Certificate",
+			&ArgumentList{MaxConcurrentDatabaseQueries: 4, Hostname: "db.database.windows.net", Authentication: AuthenticationAzureServicePrincipal, AzureClientID: "client", AzureClientCertificate: keytab + ".missing"},
+			true,
+		},
+		{
+			"Azure Managed Identity",
+			&ArgumentList{MaxConcurrentDatabaseQueries: 4, Hostname: "db.database.windows.net", Authentication: AuthenticationAzureManagedIdentity},
+			false,
+		},
+		{
+			"Azure Access Token",
+			&ArgumentList{MaxConcurrentDatabaseQueries: 4, Hostname: "db.database.windows.net", Authentication: AuthenticationAzureAccessToken, AzureAccessTokenFile: keytab},
+			false,
+		},
+		{
+			"Azure Access Token Missing File",
+			&ArgumentList{MaxConcurrentDatabaseQueries: 4, Hostname: "db.database.windows.net", Authentication: AuthenticationAzureAccessToken},
+			true,
+		},
+		{
+			"Kerberos No Credentials",
+			&ArgumentList{MaxConcurrentDatabaseQueries: 4, Hostname: "localhost", Authentication: AuthenticationKerberos},
+			true,
+		},
+	}
+
+	for _, tc := range testCases {
+		err := tc.arg.Validate()
+		if tc.wantError && err == nil {
+			t.Errorf("Test Case %s Failed: Expected error", tc.name)
+		} else if !tc.wantError && err != nil {
+			t.Errorf("Test Case %s Failed: Unexpected error: %v", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package args
+
+import (
+	"regexp"
+	"strings"
+)
+
+// CompileNamePatterns compiles a comma separated list of name patterns. A pattern is a glob supporting '*'
+// and '?' unless it is enclosed in slashes, in which case it is a regular expression. Both are case insensitive,
+// as SQL Server compares database names. Ex: 'tenant_*, /^archive_[0-9]+$/'
+func CompileNamePatterns(list string) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, 0)
+	for _, pattern := range splitPatterns(list) {
+		expr := globToRegexp(pattern)
+		if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+			expr = "(?i)" + pattern[1:len(pattern)-1]
+		}
+
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, re)
+	}
+
+	return patterns, nil
+}
+
+// splitPatterns splits a comma separated pattern list, ignoring commas inside
+// regular expressions so quantifiers like {1,3} are kept intact
+func splitPatterns(list string) []string {
+	patterns := make([]string, 0)
+	var current strings.Builder
+	inRegexp := false
+
+	flush := func() {
+		if pattern := strings.TrimSpace(current.String()); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+		current.Reset()
+	}
+
+	for _, r := range list {
+		switch {
+		case r == '/' && (inRegexp || strings.TrimSpace(current.String()) == ""):
+			inRegexp = !inRegexp
+		case r == ',' && !inRegexp:
+			flush()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	flush()
+
+	return patterns
+}
+
+func globToRegexp(glob string) string {
+	expr := regexp.QuoteMeta(glob)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+
+	return "(?i)^" + expr + "$"
+}
@@ -0,0 +1,159 @@
+package args
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Credential references that can be used in a target instead of a literal password
+const (
+	passwordEnvKey  = "password_env"
+	passwordFileKey = "password_file"
+)
+
+// nonTargetArguments cannot be overridden per target
+var nonTargetArguments = map[string]bool{
+	"targets_config":         true,
+	"max_concurrent_targets": true,
+	"collection_timeout":     true,
+	"show_version":           true,
+}
+
+// camel matches the words of a field name, following the naming the SDK uses to
+// derive argument names from ArgumentList fields (Ex: EnableSSL -> enable_ssl)
+var camel = regexp.MustCompile("(^[^A-Z]*|[A-Z]*)([A-Z][^A-Z]+|$)")
+
+// LoadTargets reads the targets config file and returns an ArgumentList for each target.
+// Targets inherit every argument from al and override the ones they define, using the
+// same names as the integration arguments. Ex:
+//
+//	targets:
+//	  - hostname: sql01.example.com
+//	    port: 1433
+//	    username: newrelic
+//	    password_env: SQL01_PASSWORD
+//	    enable_buffer_metrics: false
+func (al ArgumentList) LoadTargets() ([]ArgumentList, error) {
+	b, err := os.ReadFile(al.TargetsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read targets_config: %s", err)
+	}
+
+	var c struct {
+		Targets []map[string]interface{}
+	}
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse targets_config: %s", err)
+	}
+
+	if len(c.Targets) == 0 {
+		return nil, fmt.Errorf("no targets defined in targets_config %s", al.TargetsConfig)
+	}
+
+	targets := make([]ArgumentList, 0, len(c.Targets))
+	for index, overrides := range c.Targets {
+		target, err := al.withOverrides(overrides)
+		if err != nil {
+			return nil, fmt.Errorf("target %d: %w", index+1, err)
+		}
+		targets = append(targets, target)
+	}
+
+	return targets, nil
+}
+
+// withOverrides returns a copy of al with the arguments in overrides set
+func (al ArgumentList) withOverrides(overrides map[string]interface{}) (ArgumentList, error) {
+	target := al
+	target.TargetsConfig = ""
+
+	fields := targetFields(&target)
+	for name, value := range overrides {
+		strValue := fmt.Sprint(value)
+
+		switch name {
+		case passwordEnvKey:
+			password, ok := os.LookupEnv(strValue)
+			if !ok {
+				return target, fmt.Errorf("environment variable %s referenced by %s is not set", strValue, passwordEnvKey)
+			}
+			target.Password = password
+			continue
+		case passwordFileKey:
+			password, err := os.ReadFile(strValue)
+			if err != nil {
+				return target, fmt.Errorf("failed to read %s: %s", passwordFileKey, err)
+			}
+			target.Password = strings.TrimRight(string(password), "\r\n")
+			continue
+		}
+
+		field, ok := fields[name]
+		if !ok || nonTargetArguments[name] {
+			return target, fmt.Errorf("unknown argument '%s'", name)
+		}
+		if err := setField(field, strValue); err != nil {
+			return target, fmt.Errorf("invalid value for argument '%s': %s", name, err)
+		}
+	}
+
+	return target, nil
+}
+
+// targetFields maps the argument names to the fields of al that can be set by a target
+func targetFields(al *ArgumentList) map[string]reflect.Value {
+	val := reflect.ValueOf(al).Elem()
+	fields := make(map[string]reflect.Value, val.NumField())
+	for i := 0; i < val.NumField(); i++ {
+		typeField := val.Type().Field(i)
+		if typeField.Anonymous {
+			continue
+		}
+		fields[argumentName(typeField.Name)] = val.Field(i)
+	}
+
+	return fields
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		boolValue, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(boolValue)
+	case reflect.Int:
+		intValue, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(intValue))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Kind())
+	}
+
+	return nil
+}
+
+func argumentName(fieldName string) string {
+	var words []string
+	for _, sub := range camel.FindAllStringSubmatch(fieldName, -1) {
+		if sub[1] != "" {
+			words = append(words, sub[1])
+		}
+		if sub[2] != "" {
+			words = append(words, sub[2])
+		}
+	}
+
+	return strings.ToLower(strings.Join(words, "_"))
+}
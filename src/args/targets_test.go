@@ -0,0 +1,89 @@
+package args
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTargetsConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "targets.yml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	return path
+}
+
+func TestLoadTargets(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	assert.NoError(t, os.WriteFile(passwordFile, []byte("file-secret\n"), 0600))
+	t.Setenv("SQL01_PASSWORD", "env-secret")
+
+	base := ArgumentList{
+		Username:            "newrelic",
+		Hostname:            "127.0.0.1",
+		Timeout:             "30",
+		EnableBufferMetrics: true,
+		TargetsConfig: writeTargetsConfig(t, `
+targets:
+  - hostname: sql01.example.com
+    port: 1433
+    password_env: SQL01_PASSWORD
+    enable_buffer_metrics: false
+  - hostname: sql02.example.com
+    instance: PROD
+    username: monitor
+    password_file: `+passwordFile+`
+`),
+	}
+
+	targets, err := base.LoadTargets()
+	assert.NoError(t, err)
+	assert.Len(t, targets, 2)
+
+	assert.Equal(t, "sql01.example.com", targets[0].Hostname)
+	assert.Equal(t, "1433", targets[0].Port)
+	assert.Equal(t, "newrelic", targets[0].Username)
+	assert.Equal(t, "env-secret", targets[0].Password)
+	assert.False(t, targets[0].EnableBufferMetrics)
+	assert.Equal(t, "30", targets[0].Timeout)
+	assert.Empty(t, targets[0].TargetsConfig)
+
+	assert.Equal(t, "sql02.example.com", targets[1].Hostname)
+	assert.Equal(t, "PROD", targets[1].Instance)
+	assert.Equal(t, "monitor", targets[1].Username)
+	assert.Equal(t, "file-secret", targets[1].Password)
+	assert.True(t, targets[1].EnableBufferMetrics)
+}
+
+func TestLoadTargets_Errors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config string
+	}{
+		{"No Targets", "targets: []"},
+		{"Unknown Argument", "targets:\n  - hostname: sql01\n    not_an_argument: true"},
+		{"Non Target Argument", "targets:\n  - hostname: sql01\n    max_concurrent_targets: 2"},
+		{"Invalid Boolean", "targets:\n  - hostname: sql01\n    enable_ssl: maybe"},
+		{"Missing Password Env", "targets:\n  - hostname: sql01\n    password_env: NRI_MSSQL_UNSET_PASSWORD"},
+		{"Invalid YAML", "targets: [hostname: : sql01"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			base := ArgumentList{TargetsConfig: writeTargetsConfig(t, tc.config)}
+			_, err := base.LoadTargets()
+			assert.Error(t, err)
+		})
+	}
+}
+
+func Test_argumentName(t *testing.T) {
+	assert.Equal(t, "enable_ssl", argumentName("EnableSSL"))
+	assert.Equal(t, "server_spn", argumentName("ServerSPN"))
+	assert.Equal(t, "extra_connection_url_args", argumentName("ExtraConnectionURLArgs"))
+	assert.Equal(t, "azure_client_id", argumentName("AzureClientID"))
+}
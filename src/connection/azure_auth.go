@@ -0,0 +1,161 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/microsoft/go-mssqldb/msdsn"
+	"github.com/newrelic/nri-mssql/src/args"
+)
+
+// azureScopeSuffix is appended to the server SPN to request a token for the database resource
+const azureScopeSuffix = "/.default"
+
+var errEmptyAccessToken = errors.New("access token file is empty")
+
+// newAzureConnector creates a connector that authenticates against Azure AD (Entra ID) with the
+// method set in arguments, obtaining the tokens of the service principal or managed identity from tokens.
+// The returned connector is nil if the method is not an Azure AD one.
+func newAzureConnector(arguments *args.ArgumentList, dsn string, tokens *azureTokenProvider) (*mssql.Connector, error) {
+	if !arguments.IsAzureADAuthentication() {
+		return nil, nil
+	}
+
+	config, err := msdsn.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	switch arguments.Authentication {
+	case args.AuthenticationAzureAccessToken:
+		return mssql.NewSecurityTokenConnector(config, func(context.Context) (string, error) {
+			return readAccessToken(arguments.AzureAccessTokenFile)
+		})
+	case args.AuthenticationAzureManagedIdentity:
+		return mssql.NewActiveDirectoryTokenConnector(config, mssql.FedAuthADALWorkflowMSI, tokens.token)
+	default:
+		return mssql.NewActiveDirectoryTokenConnector(config, mssql.FedAuthADALWorkflowPassword, tokens.token)
+	}
+}
+
+// readAccessToken reads a pre-fetched access token. The file is read on each login
+// so tokens refreshed by an external process are picked up.
+func readAccessToken(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read access token: %w", err)
+	}
+
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", errEmptyAccessToken
+	}
+
+	return token, nil
+}
+
+// azureTokenProvider obtains tokens for the service principal or managed identity configured in arguments.
+// The credential is created on the first login, once the server has provided the token service to use,
+// and reused afterwards so tokens are cached between the connections of a collection, including the ones
+// to each Azure SQL Database.
+type azureTokenProvider struct {
+	arguments  *args.ArgumentList
+	lock       sync.Mutex
+	credential azcore.TokenCredential
+}
+
+// token implements the go-mssqldb Active Directory token provider. serverSPN is the database resource
+// and stsURL the token service (authority and tenant) reported by the server during login.
+func (p *azureTokenProvider) token(ctx context.Context, serverSPN, stsURL string) (string, error) {
+	credential, err := p.getCredential(stsURL)
+	if err != nil {
+		return "", err
+	}
+
+	scope := serverSPN
+	if !strings.HasSuffix(scope, azureScopeSuffix) {
+		scope += azureScopeSuffix
+	}
+
+	token, err := credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{scope}})
+	if err != nil {
+		return "", err
+	}
+
+	return token.Token, nil
+}
+
+func (p *azureTokenProvider) getCredential(stsURL string) (azcore.TokenCredential, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.credential != nil {
+		return p.credential, nil
+	}
+
+	credential, err := newAzureCredential(p.arguments, stsURL)
+	if err != nil {
+		return nil, err
+	}
+	p.credential = credential
+
+	return credential, nil
+}
+
+func newAzureCredential(arguments *args.ArgumentList, stsURL string) (azcore.TokenCredential, error) {
+	if arguments.Authentication == args.AuthenticationAzureManagedIdentity {
+		options := &azidentity.ManagedIdentityCredentialOptions{}
+		if arguments.AzureClientID != "" {
+			options.ID = azidentity.ClientID(arguments.AzureClientID)
+		}
+		return azidentity.NewManagedIdentityCredential(options)
+	}
+
+	authority, tenant := splitAuthorityAndTenant(stsURL)
+	if arguments.AzureTenantID != "" {
+		tenant = arguments.AzureTenantID
+	}
+
+	clientOptions := azcore.ClientOptions{}
+	if authority != "" {
+		clientOptions.Cloud = cloud.Configuration{ActiveDirectoryAuthorityHost: authority + "/"}
+	}
+
+	if arguments.AzureClientCertificate == "" {
+		return azidentity.NewClientSecretCredential(tenant, arguments.AzureClientID, arguments.AzureClientSecret,
+			&azidentity.ClientSecretCredentialOptions{ClientOptions: clientOptions})
+	}
+
+	certData, err := os.ReadFile(arguments.AzureClientCertificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client certificate: %w", err)
+	}
+
+	certs, key, err := azidentity.ParseCertificates(certData, []byte(arguments.AzureClientCertificatePassword))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse client certificate: %w", err)
+	}
+
+	return azidentity.NewClientCertificateCredential(tenant, arguments.AzureClientID, certs, key,
+		&azidentity.ClientCertificateCredentialOptions{ClientOptions: clientOptions})
+}
+
+// splitAuthorityAndTenant splits a token service URL such as https://login.windows.net/<tenant>
+// into the authority host and the tenant
+func splitAuthorityAndTenant(stsURL string) (authority, tenant string) {
+	separatorIndex := strings.LastIndex(stsURL, "/")
+	if separatorIndex < 0 {
+		return "", stsURL
+	}
+
+	return stsURL[:separatorIndex], stsURL[separatorIndex+1:]
+}
@@ -0,0 +1,119 @@
+package connection
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_readAccessToken(t *testing.T) {
+	dir := t.TempDir()
+
+	tokenFile := filepath.Join(dir, "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("  eyJ0eXAi.token  \n"), 0600))
+	token, err := readAccessToken(tokenFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "eyJ0eXAi.token", token)
+
+	emptyFile := filepath.Join(dir, "empty")
+	assert.NoError(t, os.WriteFile(emptyFile, []byte("\n"), 0600))
+	_, err = readAccessToken(emptyFile)
+	assert.ErrorIs(t, err, errEmptyAccessToken)
+
+	_, err = readAccessToken(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func Test_newAzureConnector(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("token"), 0600))
+
+	testCases := []struct {
+		name          string
+		arg           *args.ArgumentList
+		wantConnector bool
+	}{
+		{
+			"SQL Login",
+			&args.ArgumentList{Username: "user", Password: "pass", Hostname: "localhost", Port: "1433", Timeout: "30"},
+			false,
+		},
+		{
+			"Service Principal",
+			&args.ArgumentList{Hostname: "db.database.windows.net", Port: "1433", Timeout: "30", Authentication: args.AuthenticationAzureServicePrincipal, AzureClientID: "client", AzureClientSecret: "secret"},
+			true,
+		},
+		{
+			"Managed Identity",
+			&args.ArgumentList{Hostname: "db.database.windows.net", Port: "1433", Timeout: "30", Authentication: args.AuthenticationAzureManagedIdentity},
+			true,
+		},
+		{
+			"Access Token",
+			&args.ArgumentList{Hostname: "db.database.windows.net", Port: "1433", Timeout: "30", Authentication: args.AuthenticationAzureAccessToken, AzureAccessTokenFile: tokenFile},
+			true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			connector, err := newAzureConnector(tc.arg, CreateConnectionURL(tc.arg), &azureTokenProvider{arguments: tc.arg})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantConnector, connector != nil)
+		})
+	}
+}
+
+func Test_azureTokenProvider_ManagedIdentity(t *testing.T) {
+	// stand-in for the App Service managed identity token endpoint
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		assert.Equal(t, "identity-secret", r.Header.Get("X-IDENTITY-HEADER"))
+		assert.Equal(t, "https://database.windows.net/", r.URL.Query().Get("resource"))
+		assert.Equal(t, "user-assigned-id", r.URL.Query().Get("client_id"))
+
+		w.Header().Set("Content-Type", "application/json")
+		expiresOn := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+		fmt.Fprintf(w, `{"access_token":"managed-identity-token","expires_on":"%s","resource":"https://database.windows.net/","token_type":"Bearer"}`, expiresOn)
+	}))
+	defer server.Close()
+
+	t.Setenv("IDENTITY_ENDPOINT", server.URL)
+	t.Setenv("IDENTITY_HEADER", "identity-secret")
+
+	provider := &azureTokenProvider{
+		arguments: &args.ArgumentList{
+			Authentication: args.AuthenticationAzureManagedIdentity,
+			AzureClientID:  "user-assigned-id",
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		token, err := provider.token(context.Background(), "https://database.windows.net/", "https://login.windows.net/tenant-id")
+		assert.NoError(t, err)
+		assert.Equal(t, "managed-identity-token", token)
+	}
+	// the credential caches the token between logins
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+func Test_splitAuthorityAndTenant(t *testing.T) {
+	authority, tenant := splitAuthorityAndTenant("https://login.windows.net/72f988bf-86f1-41af-91ab-2d7cd011db47")
+	assert.Equal(t, "https://login.windows.net", authority)
+	assert.Equal(t, "72f988bf-86f1-41af-91ab-2d7cd011db47", tenant)
+
+	authority, tenant = splitAuthorityAndTenant("tenant")
+	assert.Equal(t, "", authority)
+	assert.Equal(t, "tenant", tenant)
+}
@@ -0,0 +1,96 @@
+package connection
+
+import (
+	"errors"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+)
+
+// Engine editions reported by SERVERPROPERTY('EngineEdition')
+const (
+	EngineEditionPersonal                = 1
+	EngineEditionStandard                = 2
+	EngineEditionEnterprise              = 3
+	EngineEditionExpress                 = 4
+	EngineEditionAzureSQLDatabase        = 5
+	EngineEditionAzureSynapse            = 6
+	EngineEditionAzureSQLManagedInstance = 8
+	EngineEditionAzureSQLEdge            = 9
+)
+
+// Platforms the server runs on
+const (
+	PlatformWindows = "Windows"
+	PlatformLinux   = "Linux"
+	PlatformAzure   = "Azure"
+)
+
+// hostInfoMinVersion is the first major version with sys.dm_os_host_info (SQL Server 2017)
+const hostInfoMinVersion = 14
+
+// serverInfoQuery gets the major version and engine edition. ProductMajorVersion is not
+// available in every version, so it falls back to the first part of ProductVersion.
+const serverInfoQuery = `SELECT
+	COALESCE(CAST(SERVERPROPERTY('ProductMajorVersion') AS int),
+		CAST(PARSENAME(CAST(SERVERPROPERTY('ProductVersion') AS nvarchar(128)), 4) AS int)) AS major_version,
+	CAST(SERVERPROPERTY('EngineEdition') AS int) AS engine_edition`
+
+const hostPlatformQuery = "SELECT host_platform FROM sys.dm_os_host_info"
+
+// ServerInfo describes the server behind a connection, used to run only the queries it supports
+type ServerInfo struct {
+	MajorVersion  int
+	EngineEdition int
+	Platform      string
+}
+
+// IsAzureSQLDatabase returns true if the server is an Azure SQL Database
+func (si ServerInfo) IsAzureSQLDatabase() bool {
+	return si.EngineEdition == EngineEditionAzureSQLDatabase
+}
+
+// isAzure returns true for the editions only available as an Azure service
+func (si ServerInfo) isAzure() bool {
+	switch si.EngineEdition {
+	case EngineEditionAzureSQLDatabase, EngineEditionAzureSynapse, EngineEditionAzureSQLManagedInstance:
+		return true
+	default:
+		return false
+	}
+}
+
+// detectServerInfo queries the version, edition and platform of the server
+func detectServerInfo(sc *SQLConnection) (*ServerInfo, error) {
+	infoRows := make([]struct {
+		MajorVersion  *int `db:"major_version"`
+		EngineEdition *int `db:"engine_edition"`
+	}, 0)
+	if err := sc.Query(&infoRows, serverInfoQuery); err != nil {
+		return nil, err
+	}
+	if len(infoRows) != 1 || infoRows[0].MajorVersion == nil || infoRows[0].EngineEdition == nil {
+		return nil, errors.New("server version and edition not available")
+	}
+
+	info := &ServerInfo{
+		MajorVersion:  *infoRows[0].MajorVersion,
+		EngineEdition: *infoRows[0].EngineEdition,
+		Platform:      PlatformWindows,
+	}
+
+	switch {
+	case info.isAzure():
+		info.Platform = PlatformAzure
+	case info.MajorVersion >= hostInfoMinVersion:
+		platformRows := make([]struct {
+			Platform string `db:"host_platform"`
+		}, 0)
+		if err := sc.Query(&platformRows, hostPlatformQuery); err != nil {
+			log.Warn("Unable to detect the server platform, assuming %s: %s", PlatformWindows, err.Error())
+		} else if len(platformRows) == 1 {
+			info.Platform = platformRows[0].Platform
+		}
+	}
+
+	return info, nil
+}
@@ -0,0 +1,56 @@
+package connection
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func Test_detectServerInfo(t *testing.T) {
+	testCases := []struct {
+		name          string
+		majorVersion  int
+		engineEdition int
+		hostPlatform  string
+		hostErr       error
+		expected      ServerInfo
+	}{
+		{"SQL Server 2019 on Linux", 15, EngineEditionStandard, "Linux", nil, ServerInfo{15, EngineEditionStandard, PlatformLinux}},
+		{"SQL Server 2022 on Windows", 16, EngineEditionEnterprise, "Windows", nil, ServerInfo{16, EngineEditionEnterprise, PlatformWindows}},
+		{"Host Info Error", 14, EngineEditionExpress, "", errors.New("permission denied"), ServerInfo{14, EngineEditionExpress, PlatformWindows}},
+		{"SQL Server 2012", 11, EngineEditionEnterprise, "", nil, ServerInfo{11, EngineEditionEnterprise, PlatformWindows}},
+		{"Azure SQL Database", 12, EngineEditionAzureSQLDatabase, "", nil, ServerInfo{12, EngineEditionAzureSQLDatabase, PlatformAzure}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, mock := CreateMockSQL(t)
+
+			mock.ExpectQuery(`SERVERPROPERTY\('ProductMajorVersion'\)`).
+				WillReturnRows(sqlmock.NewRows([]string{"major_version", "engine_edition"}).AddRow(tc.majorVersion, tc.engineEdition))
+			if tc.hostPlatform != "" {
+				mock.ExpectQuery(`SELECT host_platform FROM sys\.dm_os_host_info`).
+					WillReturnRows(sqlmock.NewRows([]string{"host_platform"}).AddRow(tc.hostPlatform))
+			} else if tc.hostErr != nil {
+				mock.ExpectQuery(`SELECT host_platform FROM sys\.dm_os_host_info`).WillReturnError(tc.hostErr)
+			}
+
+			info, err := detectServerInfo(conn)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, *info)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func Test_detectServerInfo_Error(t *testing.T) {
+	conn, mock := CreateMockSQL(t)
+
+	mock.ExpectQuery(`SERVERPROPERTY\('ProductMajorVersion'\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"major_version", "engine_edition"}).AddRow(nil, nil))
+
+	_, err := detectServerInfo(conn)
+	assert.Error(t, err)
+}
@@ -2,13 +2,20 @@
 package connection
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	// go-mssqldb is required for mssql driver but isn't used in code
 	"github.com/jmoiron/sqlx"
 	_ "github.com/microsoft/go-mssqldb"
+	// integrated authentication providers are registered on import
+	_ "github.com/microsoft/go-mssqldb/integratedauth/krb5"
+	_ "github.com/microsoft/go-mssqldb/integratedauth/ntlm"
 	"github.com/newrelic/infra-integrations-sdk/v3/log"
 	"github.com/newrelic/nri-mssql/src/args"
 )
@@ -17,20 +24,114 @@ import (
 type SQLConnection struct {
 	Connection *sqlx.DB
 	Host       string
+	// ServerInfo is nil if the server could not be identified, in which case every query is run
+	ServerInfo *ServerInfo
+
+	// ctx bounds every query run through the connection and queryTimeout limits each of them
+	ctx          context.Context
+	queryTimeout time.Duration
+	// azureTokens are shared with the connections to each database, so they reuse the Azure AD tokens
+	azureTokens *azureTokenProvider
+}
+
+// Rows wraps the rows returned by Queryx, releasing the query context when closed
+type Rows struct {
+	*sqlx.Rows
+	cancel context.CancelFunc
+}
+
+// Close closes the rows and releases the query context
+func (r *Rows) Close() error {
+	defer r.cancel()
+	return r.Rows.Close()
 }
 
-// NewConnection creates a new SQLConnection from args
-func NewConnection(args *args.ArgumentList) (*SQLConnection, error) {
-	db, err := sqlx.Connect("mssql", CreateConnectionURL(args))
+// NewConnection creates a new SQLConnection from args. Queries are cancelled when ctx is done.
+func NewConnection(ctx context.Context, args *args.ArgumentList) (*SQLConnection, error) {
+	azureTokens := &azureTokenProvider{arguments: args}
+	db, err := openDB(ctx, args, CreateConnectionURL(args), azureTokens)
 	if err != nil {
 		return nil, err
 	}
+	configurePool(db, args.MaxConcurrentDatabaseQueries)
+
+	sc := &SQLConnection{
+		Connection:   db,
+		Host:         args.Hostname,
+		ctx:          ctx,
+		queryTimeout: time.Duration(args.QueryTimeout) * time.Second,
+		azureTokens:  azureTokens,
+	}
+
+	// the server is identified once, so definitions not supported by it are not run
+	if sc.ServerInfo, err = detectServerInfo(sc); err != nil {
+		log.Warn("Unable to detect the server version and edition, running every query: %s", err.Error())
+	} else {
+		log.Debug("Detected server version %d, edition %d, platform %s", sc.ServerInfo.MajorVersion, sc.ServerInfo.EngineEdition, sc.ServerInfo.Platform)
+	}
+
+	return sc, nil
+}
+
+// ForDatabase creates a connection to the database dbName of the same server, used where queries cannot
+// switch databases with USE, such as Azure SQL Database. It runs a single query at a time and shares the
+// context, query timeout, server information and Azure AD tokens of sc. Must Close it when done.
+func (sc SQLConnection) ForDatabase(args *args.ArgumentList, dbName string) (*SQLConnection, error) {
+	dsn, err := databaseConnectionURL(args, dbName)
+	if err != nil {
+		return nil, err
+	}
+
+	azureTokens := sc.azureTokens
+	if azureTokens == nil {
+		azureTokens = &azureTokenProvider{arguments: args}
+	}
+	db, err := openDB(sc.context(), args, dsn, azureTokens)
+	if err != nil {
+		return nil, err
+	}
+	configurePool(db, 1)
+
 	return &SQLConnection{
-		Connection: db,
-		Host:       args.Hostname,
+		Connection:   db,
+		Host:         sc.Host,
+		ServerInfo:   sc.ServerInfo,
+		ctx:          sc.ctx,
+		queryTimeout: sc.queryTimeout,
+		azureTokens:  azureTokens,
 	}, nil
 }
 
+// openDB opens and verifies a database handle. Azure AD authentication methods
+// require a connector providing tokens, the rest are handled by the mssql driver.
+func openDB(ctx context.Context, arguments *args.ArgumentList, dsn string, azureTokens *azureTokenProvider) (*sqlx.DB, error) {
+	connector, err := newAzureConnector(arguments, dsn, azureTokens)
+	if err != nil {
+		return nil, err
+	}
+	if connector == nil {
+		return sqlx.ConnectContext(ctx, "mssql", dsn)
+	}
+
+	db := sqlx.NewDb(sql.OpenDB(connector), "sqlserver")
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// configurePool sizes the connection pool to the number of concurrent per-database queries,
+// keeping the connections idle between queries instead of reopening them
+func configurePool(db *sqlx.DB, maxConcurrentQueries int) {
+	if maxConcurrentQueries < 1 {
+		return
+	}
+	db.SetMaxOpenConns(maxConcurrentQueries)
+	db.SetMaxIdleConns(maxConcurrentQueries)
+}
+
 // Close closes the SQL connection. If an error occurs
 // it is logged as a warning.
 func (sc SQLConnection) Close() {
@@ -42,12 +143,44 @@ func (sc SQLConnection) Close() {
 // Query runs a query and loads results into v
 func (sc SQLConnection) Query(v interface{}, query string) error {
 	log.Debug("Running query: %s", query)
-	return sc.Connection.Select(v, query)
+	ctx, cancel := sc.queryContext()
+	defer cancel()
+
+	return sc.Connection.SelectContext(ctx, v, query)
+}
+
+// Queryx runs a query and returns a set of rows. The rows must be closed
+// to release the query context.
+func (sc SQLConnection) Queryx(query string) (*Rows, error) {
+	ctx, cancel := sc.queryContext()
+	rows, err := sc.Connection.QueryxContext(ctx, query)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &Rows{Rows: rows, cancel: cancel}, nil
+}
+
+// Err returns the error of the connection context once it is done, Ex: the collection timeout
+// was reached. Queries run after that fail, so remaining work should be skipped.
+func (sc SQLConnection) Err() error {
+	return sc.context().Err()
+}
+
+// queryContext returns the context of a single query, limited to the query timeout if any
+func (sc SQLConnection) queryContext() (context.Context, context.CancelFunc) {
+	if sc.queryTimeout <= 0 {
+		return context.WithCancel(sc.context())
+	}
+	return context.WithTimeout(sc.context(), sc.queryTimeout)
 }
 
-// Queryx runs a query and returns a set of rows
-func (sc SQLConnection) Queryx(query string) (*sqlx.Rows, error) {
-	return sc.Connection.Queryx(query)
+func (sc SQLConnection) context() context.Context {
+	if sc.ctx == nil {
+		return context.Background()
+	}
+	return sc.ctx
 }
 
 // CreateConnectionURL tags in args and creates the connection string.
@@ -86,6 +219,8 @@ func CreateConnectionURL(args *args.ArgumentList) string {
 
 	}
 
+	addAuthenticationParameters(query, args)
+
 	if args.EnableSSL {
 		query.Add("encrypt", "true")
 
@@ -102,3 +237,50 @@ func CreateConnectionURL(args *args.ArgumentList) string {
 
 	return connectionString
 }
+
+// databaseConnectionURL creates the connection string for the database dbName,
+// replacing any database set in the extra connection URL arguments
+func databaseConnectionURL(args *args.ArgumentList, dbName string) (string, error) {
+	connectionURL, err := url.Parse(CreateConnectionURL(args))
+	if err != nil {
+		return "", err
+	}
+
+	query := connectionURL.Query()
+	for key := range query {
+		if strings.EqualFold(key, "database") {
+			query.Del(key)
+		}
+	}
+	query.Set("database", dbName)
+	connectionURL.RawQuery = query.Encode()
+
+	return connectionURL.String(), nil
+}
+
+// addAuthenticationParameters adds the connection parameters required by integrated authentication methods.
+// SQL Server logins only need the user and password already present in the connection URL.
+func addAuthenticationParameters(query url.Values, arguments *args.ArgumentList) {
+	switch arguments.Authentication {
+	case args.AuthenticationNTLM:
+		query.Add("authenticator", "ntlm")
+	case args.AuthenticationKerberos:
+		query.Add("authenticator", "krb5")
+
+		optionalParameters := []struct {
+			name  string
+			value string
+		}{
+			{"krb5-configfile", arguments.KerberosConfigFile},
+			{"krb5-keytabfile", arguments.KerberosKeytabFile},
+			{"krb5-credcachefile", arguments.KerberosCredentialCacheFile},
+			{"krb5-realm", arguments.KerberosRealm},
+			{"ServerSPN", arguments.ServerSPN},
+		}
+		for _, param := range optionalParameters {
+			if param.value != "" {
+				query.Add(param.name, param.value)
+			}
+		}
+	}
+}
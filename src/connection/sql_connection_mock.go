@@ -1,6 +1,7 @@
 package connection
 
 import (
+	"context"
 	"testing"
 
 	"github.com/jmoiron/sqlx"
@@ -22,3 +23,11 @@ func CreateMockSQL(t *testing.T) (con *SQLConnection, mock sqlmock.Sqlmock) {
 
 	return
 }
+
+// CreateMockSQLContext creates a Test SQLConnection whose queries are cancelled when ctx is done. Must Close con when done
+func CreateMockSQLContext(t *testing.T, ctx context.Context) (con *SQLConnection, mock sqlmock.Sqlmock) {
+	con, mock = CreateMockSQL(t)
+	con.ctx = ctx
+
+	return
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/newrelic/nri-mssql/src/args"
+)
+
+// ExcludedDatabasesPlaceHolder placeholder for the list of databases excluded from monitoring in a query
+const ExcludedDatabasesPlaceHolder = "%EXCLUDED_DATABASES%"
+
+// systemDatabases are the SQL Server system databases, only monitored when explicitly included
+var systemDatabases = []string{"master", "tempdb", "msdb", "model"}
+
+// internalDatabases are databases created by managed services or replication that are never monitored
+var internalDatabases = []string{"rdsadmin", "distribution", "model_msdb", "model_replicatedmaster"}
+
+// maxDiscoveredExclusions is the most databases left out by the patterns that queries exclude. Beyond it the
+// queries return their rows too, which are skipped as they have no entity, to keep the queries short.
+const maxDiscoveredExclusions = 100
+
+// NameFilter decides which databases are monitored based on include and exclude patterns
+type NameFilter struct {
+	include       []*regexp.Regexp
+	exclude       []*regexp.Regexp
+	includeSystem bool
+	// discoveredExcluded are the databases found by discovery that do not match the patterns
+	discoveredExcluded []string
+}
+
+// NewNameFilter creates a NameFilter from comma separated include and exclude pattern lists,
+// as described in args.CompileNamePatterns.
+// System databases are left out by queries unless includeSystem is true.
+func NewNameFilter(include, exclude string, includeSystem bool) (*NameFilter, error) {
+	includePatterns, err := args.CompileNamePatterns(include)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database include pattern: %w", err)
+	}
+
+	excludePatterns, err := args.CompileNamePatterns(exclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database exclude pattern: %w", err)
+	}
+
+	return &NameFilter{
+		include:       includePatterns,
+		exclude:       excludePatterns,
+		includeSystem: includeSystem,
+	}, nil
+}
+
+// ExcludedDatabases returns the databases that queries must leave out, including the ones
+// left out by the patterns once discovered, unless there are more than maxDiscoveredExclusions.
+// A nil filter excludes system databases.
+func (f *NameFilter) ExcludedDatabases() []string {
+	excluded := make([]string, 0, len(systemDatabases)+len(internalDatabases))
+	if f == nil || !f.includeSystem {
+		excluded = append(excluded, systemDatabases...)
+	}
+	excluded = append(excluded, internalDatabases...)
+
+	if f != nil && len(f.discoveredExcluded) <= maxDiscoveredExclusions {
+		excluded = append(excluded, f.discoveredExcluded...)
+	}
+	return excluded
+}
+
+// excludeDiscovered records a discovered database not matching the patterns,
+// so the queries returning a row for each database leave it out
+func (f *NameFilter) excludeDiscovered(dbName string) {
+	if f != nil {
+		f.discoveredExcluded = append(f.discoveredExcluded, dbName)
+	}
+}
+
+// ExclusionList returns ExcludedDatabases formatted as a list of SQL string literals
+// to be used in a NOT IN clause. Ex: 'rdsadmin', 'distribution'
+func (f *NameFilter) ExclusionList() string {
+	excluded := f.ExcludedDatabases()
+	literals := make([]string, 0, len(excluded))
+	for _, dbName := range excluded {
+		literals = append(literals, "'"+strings.ReplaceAll(dbName, "'", "''")+"'")
+	}
+
+	return strings.Join(literals, ", ")
+}
+
+// Match returns true if the database should be monitored. A database is monitored when it matches
+// any include pattern (or no include pattern is defined) and it does not match any exclude pattern.
+// A nil filter matches every database.
+func (f *NameFilter) Match(dbName string) bool {
+	if f == nil {
+		return true
+	}
+
+	if len(f.include) > 0 && !matchAny(f.include, dbName) {
+		return false
+	}
+
+	return !matchAny(f.exclude, dbName)
+}
+
+func matchAny(patterns []*regexp.Regexp, dbName string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(dbName) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NameFilter_Match(t *testing.T) {
+	testCases := []struct {
+		name     string
+		include  string
+		exclude  string
+		matches  []string
+		excluded []string
+	}{
+		{
+			"No Patterns",
+			"",
+			"",
+			[]string{"master", "tenant_1", "Sales"},
+			[]string{},
+		},
+		{
+			"Include Glob",
+			"tenant_*, sales",
+			"",
+			[]string{"tenant_1", "TENANT_abc", "Sales"},
+			[]string{"master", "salesarchive"},
+		},
+		{
+			"Exclude Glob",
+			"",
+			"*_archive,test?",
+			[]string{"master", "test", "tests_db"},
+			[]string{"sales_archive", "test1"},
+		},
+		{
+			"Include And Exclude",
+			"tenant_*",
+			"tenant_9*",
+			[]string{"tenant_1", "tenant_80"},
+			[]string{"tenant_9", "tenant_99", "master"},
+		},
+		{
+			"Regular Expressions",
+			"/^tenant_[0-9]{1,3}$/, reporting",
+			"/^tenant_0/",
+			[]string{"tenant_1", "tenant_123", "reporting"},
+			[]string{"tenant_1234", "tenant_abc", "tenant_01"},
+		},
+		{
+			"Case Insensitive Regular Expressions",
+			"/^prod_/",
+			"/_ARCHIVE$/",
+			[]string{"prod_sales", "PROD_sales", "Prod_Stock"},
+			[]string{"prod_sales_archive", "PROD_SALES_ARCHIVE", "dev_sales"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			filter, err := NewNameFilter(tc.include, tc.exclude, false)
+			assert.NoError(t, err)
+
+			for _, dbName := range tc.matches {
+				assert.True(t, filter.Match(dbName), "expected '%s' to match", dbName)
+			}
+			for _, dbName := range tc.excluded {
+				assert.False(t, filter.Match(dbName), "expected '%s' to be excluded", dbName)
+			}
+		})
+	}
+}
+
+func Test_NameFilter_InvalidRegexp(t *testing.T) {
+	_, err := NewNameFilter("/tenant_[0-9/", "", false)
+	assert.Error(t, err)
+
+	_, err = NewNameFilter("", "/(archive/", false)
+	assert.Error(t, err)
+}
+
+func Test_NameFilter_Nil(t *testing.T) {
+	var filter *NameFilter
+	assert.True(t, filter.Match("anything"))
+	assert.Equal(t, "'master', 'tempdb', 'msdb', 'model', 'rdsadmin', 'distribution', 'model_msdb', 'model_replicatedmaster'", filter.ExclusionList())
+}
+
+func Test_NameFilter_ExcludedDatabases(t *testing.T) {
+	filter, err := NewNameFilter("", "", false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"master", "tempdb", "msdb", "model", "rdsadmin", "distribution", "model_msdb", "model_replicatedmaster"}, filter.ExcludedDatabases())
+
+	filter, err = NewNameFilter("", "", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"rdsadmin", "distribution", "model_msdb", "model_replicatedmaster"}, filter.ExcludedDatabases())
+	assert.Equal(t, "'rdsadmin', 'distribution', 'model_msdb', 'model_replicatedmaster'", filter.ExclusionList())
+}
+
+func Test_NameFilter_ExcludedDatabases_Discovered(t *testing.T) {
+	filter, err := NewNameFilter("", "archive_*", true)
+	assert.NoError(t, err)
+	filter.excludeDiscovered("archive_1")
+	filter.excludeDiscovered("archive_2")
+	assert.Equal(t, "'rdsadmin', 'distribution', 'model_msdb', 'model_replicatedmaster', 'archive_1', 'archive_2'", filter.ExclusionList())
+
+	// too many to list in the queries, their rows are skipped instead
+	for i := 3; i <= 1000; i++ {
+		filter.excludeDiscovered(fmt.Sprintf("archive_%d", i))
+	}
+	assert.Equal(t, "'rdsadmin', 'distribution', 'model_msdb', 'model_replicatedmaster'", filter.ExclusionList())
+}
@@ -3,15 +3,17 @@ package database
 
 import (
 	"reflect"
+	"strings"
 
 	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
 	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
 	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
 	"github.com/newrelic/nri-mssql/src/connection"
 )
 
-// databaseNameQuery gets all database names
-const databaseNameQuery = "select name as db_name from sys.databases where name not in ('master', 'tempdb', 'msdb', 'model', 'rdsadmin', 'distribution', 'model_msdb', 'model_replicatedmaster')"
+// databaseNameQuery gets all database names not excluded from monitoring
+const databaseNameQuery = "select name as db_name from sys.databases where name not in (" + ExcludedDatabasesPlaceHolder + ")"
 
 // NameRow is a row result in the databaseNameQuery
 type NameRow struct {
@@ -33,16 +35,24 @@ func (dm DataModel) GetDBName() string {
 	return dm.DBName
 }
 
-// CreateDatabaseEntities instantiates an entity for each database we're collecting
-func CreateDatabaseEntities(i *integration.Integration, con *connection.SQLConnection, instanceName string) ([]*integration.Entity, error) {
+// CreateDatabaseEntities instantiates an entity for each database we're collecting.
+// Databases not matched by filter are skipped, and left out of the queries using its exclusion list.
+func CreateDatabaseEntities(i *integration.Integration, con *connection.SQLConnection, instanceName string, filter *NameFilter) ([]*integration.Entity, error) {
 	databaseRows := make([]*NameRow, 0)
-	if err := con.Query(&databaseRows, databaseNameQuery); err != nil {
+	query := strings.Replace(databaseNameQuery, ExcludedDatabasesPlaceHolder, filter.ExclusionList(), -1)
+	if err := con.Query(&databaseRows, query); err != nil {
 		return nil, err
 	}
 
 	instanceIDAttr := integration.NewIDAttribute("instance", instanceName)
 	dbEntities := make([]*integration.Entity, 0, len(databaseRows))
 	for _, row := range databaseRows {
+		if !filter.Match(row.DBName) {
+			log.Debug("Database '%s' is excluded from monitoring", row.DBName)
+			filter.excludeDiscovered(row.DBName)
+			continue
+		}
+
 		databaseIDAttr := integration.NewIDAttribute("database", row.DBName)
 		dbEntity, err := i.EntityReportedVia(con.Host, row.DBName, "ms-database", instanceIDAttr, databaseIDAttr)
 		if err != nil {
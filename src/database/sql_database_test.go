@@ -4,6 +4,7 @@ import (
 	"errors"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 
 	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
@@ -24,7 +25,7 @@ func Test_createDatabaseEntities_QueryError(t *testing.T) {
 	mock.ExpectQuery(`select name as db_name from sys.databases where`).WillReturnError(errors.New("error"))
 
 	instanceName := "testInstanceName"
-	if _, err := CreateDatabaseEntities(i, conn, instanceName); err == nil {
+	if _, err := CreateDatabaseEntities(i, conn, instanceName, nil); err == nil {
 		t.Error("Did not return expected error")
 	}
 }
@@ -44,7 +45,7 @@ func Test_createDatabaseEntities(t *testing.T) {
 	mock.ExpectQuery(`select name as db_name from sys.databases where`).WillReturnRows(rows)
 
 	instanceName := "testInstanceName"
-	dbEntities, err := CreateDatabaseEntities(i, conn, instanceName)
+	dbEntities, err := CreateDatabaseEntities(i, conn, instanceName, nil)
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err.Error())
 		t.FailNow()
@@ -62,6 +63,43 @@ func Test_createDatabaseEntities(t *testing.T) {
 	}
 }
 
+func Test_createDatabaseEntities_Filtered(t *testing.T) {
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Errorf("Unexpected error %s", err.Error())
+		t.FailNow()
+	}
+
+	conn, mock := connection.CreateMockSQL(t)
+
+	rows := sqlmock.NewRows([]string{"db_name"}).
+		AddRow("tenant_1").
+		AddRow("tenant_2").
+		AddRow("reporting")
+	mock.ExpectQuery(`select name as db_name from sys.databases where`).WillReturnRows(rows)
+
+	filter, err := NewNameFilter("tenant_*", "tenant_2", false)
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+		t.FailNow()
+	}
+
+	dbEntities, err := CreateDatabaseEntities(i, conn, "testInstanceName", filter)
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+		t.FailNow()
+	}
+
+	if len(dbEntities) != 1 || dbEntities[0].Metadata.Name != "tenant_1" {
+		t.Errorf("Expected only entity 'tenant_1' got %+v", dbEntities)
+	}
+
+	// the databases discovered and excluded are left out of the queries of every database
+	if exclusionList := filter.ExclusionList(); !strings.Contains(exclusionList, "'tenant_2', 'reporting'") || strings.Contains(exclusionList, "tenant_1") {
+		t.Errorf("Expected 'tenant_2' and 'reporting' to be excluded got %s", exclusionList)
+	}
+}
+
 func Test_DBMetricSetLookup_GetDBNames(t *testing.T) {
 	expected := []string{"one", "three", "two"}
 
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/newrelic/nri-mssql/src/connection"
+)
+
+// agentEditions are the engine editions running SQL Server Agent
+var agentEditions = []int{
+	connection.EngineEditionStandard,
+	connection.EngineEditionEnterprise,
+	connection.EngineEditionAzureSQLManagedInstance,
+}
+
+// Placeholders of the job history range in which failures are counted
+const (
+	lastHistoryIDPlaceHolder = "%LAST_HISTORY_ID%"
+	maxHistoryIDPlaceHolder  = "%MAX_HISTORY_ID%"
+)
+
+// agentJobHistoryModel is the last entry of the job history
+type agentJobHistoryModel struct {
+	MaxHistoryID *int64 `db:"max_instance_id"`
+}
+
+// agentJobModel is a row of the agent job query
+type agentJobModel struct {
+	JobID               string  `db:"job_id"`
+	JobName             string  `db:"job_name"`
+	Category            *string `db:"category_name"`
+	Enabled             *int64  `db:"enabled" metric_name:"job.enabled" source_type:"gauge"`
+	LastRunOutcome      *string `db:"last_run_outcome" metric_name:"job.lastRunOutcome" source_type:"attribute"`
+	LastRunStatus       *int64  `db:"last_run_status" metric_name:"job.lastRunStatus" source_type:"gauge"`
+	LastRunDuration     *int64  `db:"last_run_duration_seconds" metric_name:"job.lastRunDurationInSeconds" source_type:"gauge"`
+	SecondsSinceLastRun *int64  `db:"seconds_since_last_run" metric_name:"job.secondsSinceLastRun" source_type:"gauge"`
+	NextRunTime         *string `db:"next_run_time" metric_name:"job.nextRunTime" source_type:"attribute"`
+	SecondsUntilNextRun *int64  `db:"seconds_until_next_run" metric_name:"job.secondsUntilNextRun" source_type:"gauge"`
+	IsRunning           *int64  `db:"is_running" metric_name:"job.isRunning" source_type:"gauge"`
+	CurrentRunDuration  *int64  `db:"current_run_duration_seconds" metric_name:"job.currentRunDurationInSeconds" source_type:"gauge"`
+	Failures            *int64  `db:"failures" metric_name:"job.failuresSinceLastCollection" source_type:"gauge"`
+}
+
+// agentJobHistoryDefinition gets the id of the last job history entry, the upper bound of the failures counted
+var agentJobHistoryDefinition = &QueryDefinition{
+	name:       "agent_job_history",
+	query:      "SELECT Max(instance_id) AS max_instance_id FROM msdb.dbo.sysjobhistory",
+	dataModels: &[]agentJobHistoryModel{},
+	editions:   agentEditions,
+}
+
+// agentJobDefinition gets the state of every job. The outcome is the one of the last completed run,
+// the activity of the current Agent session tells if the job is running and its next run.
+// Failures are the failed runs in the job history range not counted yet.
+var agentJobDefinition = &QueryDefinition{
+	name: "agent_jobs",
+	query: `SELECT
+		CAST(j.job_id AS nvarchar(36)) AS job_id,
+		j.name AS job_name,
+		c.name AS category_name,
+		CAST(j.enabled AS int) AS enabled,
+		lh.run_status AS last_run_status,
+		CASE lh.run_status WHEN 0 THEN 'Failed' WHEN 1 THEN 'Succeeded' WHEN 2 THEN 'Retry' WHEN 3 THEN 'Canceled' WHEN 4 THEN 'In Progress' END AS last_run_outcome,
+		(lh.run_duration / 10000) * 3600 + (lh.run_duration / 100 % 100) * 60 + lh.run_duration % 100 AS last_run_duration_seconds,
+		DATEDIFF(SECOND, DATEADD(SECOND, (lh.run_time / 10000) * 3600 + (lh.run_time / 100 % 100) * 60 + lh.run_time % 100,
+			CONVERT(datetime, CAST(lh.run_date AS char(8)), 112)), GETDATE()) AS seconds_since_last_run,
+		CONVERT(varchar(19), ja.next_scheduled_run_date, 126) AS next_run_time,
+		DATEDIFF(SECOND, GETDATE(), ja.next_scheduled_run_date) AS seconds_until_next_run,
+		CASE WHEN ja.start_execution_date IS NOT NULL AND ja.stop_execution_date IS NULL THEN 1 ELSE 0 END AS is_running,
+		CASE WHEN ja.start_execution_date IS NOT NULL AND ja.stop_execution_date IS NULL
+			THEN DATEDIFF(SECOND, ja.start_execution_date, GETDATE()) END AS current_run_duration_seconds,
+		(SELECT Count(*) FROM msdb.dbo.sysjobhistory fh
+			WHERE fh.job_id = j.job_id AND fh.step_id = 0 AND fh.run_status = 0
+			AND fh.instance_id > ` + lastHistoryIDPlaceHolder + ` AND fh.instance_id <= ` + maxHistoryIDPlaceHolder + `) AS failures
+		FROM msdb.dbo.sysjobs j
+		LEFT JOIN msdb.dbo.syscategories c ON c.category_id = j.category_id
+		OUTER APPLY (
+			SELECT TOP 1 h.run_status, h.run_date, h.run_time, h.run_duration FROM msdb.dbo.sysjobhistory h
+			WHERE h.job_id = j.job_id AND h.step_id = 0 ORDER BY h.instance_id DESC
+		) lh
+		OUTER APPLY (
+			SELECT TOP 1 a.start_execution_date, a.stop_execution_date, a.next_scheduled_run_date FROM msdb.dbo.sysjobactivity a
+			WHERE a.job_id = j.job_id AND a.session_id = (SELECT Max(session_id) FROM msdb.dbo.syssessions)
+		) ja`,
+	dataModels: &[]agentJobModel{},
+	editions:   agentEditions,
+}
+
+// historyRangeReplace sets the job history range in which failures are counted
+func historyRangeReplace(lastHistoryID, maxHistoryID int64) QueryModifier {
+	return func(query string) string {
+		query = strings.Replace(query, lastHistoryIDPlaceHolder, strconv.FormatInt(lastHistoryID, 10), -1)
+		return strings.Replace(query, maxHistoryIDPlaceHolder, strconv.FormatInt(maxHistoryID, 10), -1)
+	}
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/state"
+)
+
+// lastHistoryIDKey stores the last job history entry whose failures were reported
+const lastHistoryIDKey = "agentJobs.lastHistoryID"
+
+// PopulateAgentJobMetrics reports an MssqlAgentJobSample for every SQL Server Agent job. Failures are
+// counted from the job history entries added since the previous collection, kept in the state of the instance, so each
+// failure is reported once. The first collection only records where the history ends.
+func PopulateAgentJobMetrics(instanceEntity *integration.Entity, con *connection.SQLConnection, arguments args.ArgumentList, instanceState *state.State, telemetry *Telemetry) {
+	if !arguments.EnableAgentJobMetrics {
+		telemetry.recordDisabled(agentJobHistoryDefinition, agentJobDefinition)
+		return
+	}
+
+	if instanceState == nil {
+		telemetry.recordSkipped(agentJobHistoryDefinition, agentJobDefinition)
+		return
+	}
+
+	if err := con.Err(); err != nil {
+		log.Warn("Skipping agent job queries: %s", err.Error())
+		telemetry.recordSkipped(agentJobHistoryDefinition, agentJobDefinition)
+		return
+	}
+
+	historyDef, ok := agentJobHistoryDefinition.forServer(con.ServerInfo)
+	if !ok {
+		telemetry.recordUnsupported(agentJobHistoryDefinition, agentJobDefinition)
+		return
+	}
+
+	history := make([]agentJobHistoryModel, 0)
+	if err := runQueryDefinition(con, telemetry, historyDef, historyDef.GetQuery(), &history); err != nil {
+		log.Error("Could not execute agent job history query: %s", err.Error())
+		telemetry.recordSkipped(agentJobDefinition)
+		return
+	}
+	var maxHistoryID int64
+	if len(history) == 1 && history[0].MaxHistoryID != nil {
+		maxHistoryID = *history[0].MaxHistoryID
+	}
+
+	lastHistoryID, ok := instanceState.IDWatermark(lastHistoryIDKey)
+	if !ok {
+		lastHistoryID = maxHistoryID
+	}
+	// the history ids restart if msdb is recreated or restored
+	if lastHistoryID > maxHistoryID {
+		log.Debug("Agent job history restarted, counting failures from the beginning")
+		lastHistoryID = 0
+	}
+
+	jobs := make([]agentJobModel, 0)
+	query := agentJobDefinition.GetQuery(historyRangeReplace(lastHistoryID, maxHistoryID))
+	if err := runQueryDefinition(con, telemetry, agentJobDefinition, query, &jobs); err != nil {
+		log.Error("Could not execute agent job query: %s", err.Error())
+		return
+	}
+	// only move forward once the failures up to maxHistoryID are reported
+	instanceState.SetIDWatermark(lastHistoryIDKey, maxHistoryID)
+
+	for _, job := range jobs {
+		attributes := []attribute.Attribute{
+			{Key: "displayName", Value: instanceEntity.Metadata.Name},
+			{Key: "entityName", Value: instanceEntity.Metadata.Namespace + ":" + instanceEntity.Metadata.Name},
+			{Key: "host", Value: con.Host},
+			{Key: "instance", Value: instanceEntity.Metadata.Name},
+			{Key: "jobName", Value: job.JobName},
+			{Key: "jobId", Value: job.JobID},
+		}
+		if job.Category != nil {
+			attributes = append(attributes, attribute.Attribute{Key: "jobCategory", Value: *job.Category})
+		}
+
+		metricSet := instanceEntity.NewMetricSet("MssqlAgentJobSample", attributes...)
+		if err := metricSet.MarshalMetrics(job); err != nil {
+			log.Error("Could not parse metrics of agent job '%s': %s", job.JobName, err.Error())
+		}
+	}
+}
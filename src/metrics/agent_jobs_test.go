@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/persist"
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/state"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func agentJobRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"job_id", "job_name", "category_name", "enabled", "last_run_status", "last_run_outcome", "last_run_duration_seconds", "seconds_since_last_run", "next_run_time", "seconds_until_next_run", "is_running", "current_run_duration_seconds", "failures"}).
+		AddRow("8c4e5f0e-2c61-4b6a-9d7b-1f1e3d2c7a10", "nightly backup", "Database Maintenance", 1, 0, "Failed", 95, 3600, "2026-10-18T01:00:00", 30000, 0, nil, 2)
+}
+
+func Test_populateAgentJobMetrics(t *testing.T) {
+	_, e := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	store := persist.NewInMemoryStore()
+	store.Set(lastHistoryIDKey, int64(5))
+
+	mock.ExpectQuery(`SELECT Max\(instance_id\) AS max_instance_id FROM msdb\.dbo\.sysjobhistory`).
+		WillReturnRows(sqlmock.NewRows([]string{"max_instance_id"}).AddRow(8))
+	mock.ExpectQuery(`fh\.instance_id > 5 AND fh\.instance_id <= 8`).
+		WillReturnRows(agentJobRows())
+
+	telemetry := NewTelemetry()
+	PopulateAgentJobMetrics(e, conn, args.ArgumentList{EnableAgentJobMetrics: true}, state.New(store, time.Time{}), telemetry)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var lastHistoryID int64
+	_, err := store.Get(lastHistoryIDKey, &lastHistoryID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), lastHistoryID)
+
+	assert.Len(t, e.Metrics, 1)
+	jobMetrics := e.Metrics[0].Metrics
+	assert.Equal(t, "MssqlAgentJobSample", jobMetrics["event_type"])
+	assert.Equal(t, "nightly backup", jobMetrics["jobName"])
+	assert.Equal(t, "Database Maintenance", jobMetrics["jobCategory"])
+	assert.Equal(t, "Failed", jobMetrics["job.lastRunOutcome"])
+	assert.Equal(t, float64(95), jobMetrics["job.lastRunDurationInSeconds"])
+	assert.Equal(t, "2026-10-18T01:00:00", jobMetrics["job.nextRunTime"])
+	assert.Equal(t, float64(2), jobMetrics["job.failuresSinceLastCollection"])
+	assert.NotContains(t, jobMetrics, "job.currentRunDurationInSeconds")
+}
+
+func Test_populateAgentJobMetrics_HistoryRange(t *testing.T) {
+	testCases := []struct {
+		name          string
+		lastHistoryID *int64
+		expectedRange string
+	}{
+		// the first collection does not report the failures already in the history
+		{"First Collection", nil, `fh\.instance_id > 8 AND fh\.instance_id <= 8`},
+		{"History Restarted", func() *int64 { id := int64(20); return &id }(), `fh\.instance_id > 0 AND fh\.instance_id <= 8`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, e := createTestEntity(t)
+
+			conn, mock := connection.CreateMockSQL(t)
+			defer conn.Close()
+
+			store := persist.NewInMemoryStore()
+			if tc.lastHistoryID != nil {
+				store.Set(lastHistoryIDKey, *tc.lastHistoryID)
+			}
+
+			mock.ExpectQuery(`SELECT Max\(instance_id\)`).
+				WillReturnRows(sqlmock.NewRows([]string{"max_instance_id"}).AddRow(8))
+			mock.ExpectQuery(tc.expectedRange).
+				WillReturnRows(agentJobRows())
+
+			PopulateAgentJobMetrics(e, conn, args.ArgumentList{EnableAgentJobMetrics: true}, state.New(store, time.Time{}), nil)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func Test_populateAgentJobMetrics_QueryError(t *testing.T) {
+	_, e := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	store := persist.NewInMemoryStore()
+	store.Set(lastHistoryIDKey, int64(5))
+
+	mock.ExpectQuery(`SELECT Max\(instance_id\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"max_instance_id"}).AddRow(8))
+	mock.ExpectQuery(`FROM msdb\.dbo\.sysjobs`).
+		WillReturnError(assert.AnError)
+
+	PopulateAgentJobMetrics(e, conn, args.ArgumentList{EnableAgentJobMetrics: true}, state.New(store, time.Time{}), nil)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// failures not reported are counted in the next collection
+	var lastHistoryID int64
+	_, err := store.Get(lastHistoryIDKey, &lastHistoryID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), lastHistoryID)
+	assert.Empty(t, e.Metrics)
+}
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+)
+
+// PopulateAvailabilityGroupMetrics creates an entity for every Always On availability group and replica
+// the instance takes part in, reporting their synchronization state
+func PopulateAvailabilityGroupMetrics(i *integration.Integration, instanceName string, con *connection.SQLConnection, arguments args.ArgumentList, telemetry *Telemetry) {
+	if !arguments.EnableAvailabilityGroupMetrics {
+		telemetry.recordDisabled(availabilityGroupDefinition, availabilityReplicaDefinition)
+		return
+	}
+
+	if err := con.Err(); err != nil {
+		log.Warn("Skipping availability group queries: %s", err.Error())
+		telemetry.recordSkipped(availabilityGroupDefinition, availabilityReplicaDefinition)
+		return
+	}
+
+	if queryDef, ok := availabilityGroupDefinition.forServer(con.ServerInfo); ok {
+		groups := make([]availabilityGroupModel, 0)
+		if err := runQueryDefinition(con, telemetry, queryDef, queryDef.GetQuery(), &groups); err != nil {
+			log.Error("Could not execute availability group query: %s", err.Error())
+		}
+		for _, group := range groups {
+			clusterIDAttr := integration.NewIDAttribute("cluster", group.ClusterName)
+			groupEntity, err := i.EntityReportedVia(con.Host, group.GroupName, "ms-availability-group", clusterIDAttr)
+			if err != nil {
+				log.Error("Unable to create entity for availability group '%s': %s", group.GroupName, err.Error())
+				continue
+			}
+			metricSet := newAvailabilityGroupMetricSet(groupEntity, "MssqlAvailabilityGroupSample", con.Host, instanceName, group.ClusterName, group.GroupName)
+			if err := metricSet.MarshalMetrics(group); err != nil {
+				log.Error("Could not parse metrics of availability group '%s': %s", group.GroupName, err.Error())
+			}
+		}
+	} else {
+		telemetry.recordUnsupported(availabilityGroupDefinition)
+	}
+
+	if queryDef, ok := availabilityReplicaDefinition.forServer(con.ServerInfo); ok {
+		replicas := make([]availabilityReplicaModel, 0)
+		if err := runQueryDefinition(con, telemetry, queryDef, queryDef.GetQuery(), &replicas); err != nil {
+			log.Error("Could not execute availability replica query: %s", err.Error())
+		}
+		for _, replica := range replicas {
+			clusterIDAttr := integration.NewIDAttribute("cluster", replica.ClusterName)
+			groupIDAttr := integration.NewIDAttribute("availabilityGroup", replica.GroupName)
+			replicaEntity, err := i.EntityReportedVia(con.Host, replica.ReplicaName, "ms-availability-replica", clusterIDAttr, groupIDAttr)
+			if err != nil {
+				log.Error("Unable to create entity for availability replica '%s': %s", replica.ReplicaName, err.Error())
+				continue
+			}
+			metricSet := newAvailabilityGroupMetricSet(replicaEntity, "MssqlAvailabilityReplicaSample", con.Host, instanceName, replica.ClusterName, replica.GroupName,
+				attribute.Attribute{Key: "replica", Value: replica.ReplicaName},
+			)
+			if err := metricSet.MarshalMetrics(replica); err != nil {
+				log.Error("Could not parse metrics of availability replica '%s': %s", replica.ReplicaName, err.Error())
+			}
+		}
+	} else {
+		telemetry.recordUnsupported(availabilityReplicaDefinition)
+	}
+}
+
+// newAvailabilityGroupMetricSet creates a metric set for an availability group or replica entity. The
+// instance attribute is the instance reporting it, as every replica of a group can report it. Groups
+// are identified by their cluster and name, as groups of different clusters can share the name.
+func newAvailabilityGroupMetricSet(e *integration.Entity, eventType, host, instanceName, clusterName, groupName string, attributes ...attribute.Attribute) *metric.Set {
+	attributes = append([]attribute.Attribute{
+		{Key: "displayName", Value: e.Metadata.Name},
+		{Key: "entityName", Value: e.Metadata.Namespace + ":" + e.Metadata.Name},
+		{Key: "host", Value: host},
+		{Key: "instance", Value: instanceName},
+		{Key: "cluster", Value: clusterName},
+		{Key: "availabilityGroup", Value: groupName},
+	}, attributes...)
+
+	return e.NewMetricSet(eventType, attributes...)
+}
@@ -0,0 +1,131 @@
+package metrics
+
+// availabilityGroupMinVersion is the first major version with Always On availability groups (SQL Server 2012)
+const availabilityGroupMinVersion = 11
+
+// clusterTypeMinVersion is the first major version reporting the cluster type of availability groups (SQL Server 2017)
+const clusterTypeMinVersion = 14
+
+// clusterNameQuery gets the name of the cluster the availability groups belong to, which identifies
+// them along with their name. It is empty for the groups without a cluster.
+const clusterNameQuery = `SELECT COALESCE(Max(cluster_name), '') AS cluster_name FROM sys.dm_hadr_cluster`
+
+// availabilityGroupModel is a row of the availability group query
+type availabilityGroupModel struct {
+	ClusterName           string  `db:"cluster_name"`
+	GroupName             string  `db:"ag_name"`
+	PrimaryReplica        *string `db:"primary_replica" metric_name:"ag.primaryReplica" source_type:"attribute"`
+	SynchronizationHealth *string `db:"synchronization_health_desc" metric_name:"ag.synchronizationHealthState" source_type:"attribute"`
+	ClusterType           *string `db:"cluster_type_desc" metric_name:"ag.clusterType" source_type:"attribute"`
+	HealthValue           *int64  `db:"synchronization_health" metric_name:"ag.synchronizationHealth" source_type:"gauge"`
+	Replicas              *int64  `db:"replica_count" metric_name:"ag.replicas" source_type:"gauge"`
+	Databases             *int64  `db:"database_count" metric_name:"ag.databases" source_type:"gauge"`
+}
+
+// availabilityReplicaModel is a row of the availability replica query. Queue sizes and rates
+// are the sum of the databases in the replica, estimated times the worst of them.
+type availabilityReplicaModel struct {
+	ClusterName                string   `db:"cluster_name"`
+	GroupName                  string   `db:"ag_name"`
+	ReplicaName                string   `db:"replica_server_name"`
+	Role                       *string  `db:"role_desc" metric_name:"replica.role" source_type:"attribute"`
+	AvailabilityMode           *string  `db:"availability_mode_desc" metric_name:"replica.availabilityMode" source_type:"attribute"`
+	FailoverMode               *string  `db:"failover_mode_desc" metric_name:"replica.failoverMode" source_type:"attribute"`
+	ConnectedState             *string  `db:"connected_state_desc" metric_name:"replica.connectedState" source_type:"attribute"`
+	SynchronizationHealthState *string  `db:"synchronization_health_desc" metric_name:"replica.synchronizationHealthState" source_type:"attribute"`
+	SynchronizationState       *string  `db:"synchronization_state_desc" metric_name:"replica.synchronizationState" source_type:"attribute"`
+	IsLocal                    *int64   `db:"is_local" metric_name:"replica.isLocal" source_type:"gauge"`
+	IsPrimary                  *int64   `db:"is_primary" metric_name:"replica.isPrimary" source_type:"gauge"`
+	SynchronizationHealth      *int64   `db:"synchronization_health" metric_name:"replica.synchronizationHealth" source_type:"gauge"`
+	Databases                  *int64   `db:"database_count" metric_name:"replica.databases" source_type:"gauge"`
+	SynchronizedDatabases      *int64   `db:"synchronized_database_count" metric_name:"replica.synchronizedDatabases" source_type:"gauge"`
+	LogSendQueue               *int64   `db:"log_send_queue_bytes" metric_name:"replica.logSendQueueInBytes" source_type:"gauge"`
+	LogSendRate                *int64   `db:"log_send_rate_bytes" metric_name:"replica.logSendRateInBytesPerSecond" source_type:"gauge"`
+	RedoQueue                  *int64   `db:"redo_queue_bytes" metric_name:"replica.redoQueueInBytes" source_type:"gauge"`
+	RedoRate                   *int64   `db:"redo_rate_bytes" metric_name:"replica.redoRateInBytesPerSecond" source_type:"gauge"`
+	EstimatedDataLoss          *int64   `db:"estimated_data_loss_seconds" metric_name:"replica.estimatedDataLossInSeconds" source_type:"gauge"`
+	EstimatedRecoveryTime      *float64 `db:"estimated_recovery_time_seconds" metric_name:"replica.estimatedRecoveryTimeInSeconds" source_type:"gauge"`
+}
+
+// availabilityGroupDefinition gets the health of every availability group the instance takes part in
+var availabilityGroupDefinition = &QueryDefinition{
+	name: "availability_groups",
+	query: `SELECT
+		ag.name AS ag_name,
+		ags.primary_replica,
+		ags.synchronization_health_desc,
+		ags.synchronization_health,
+		ag.cluster_type_desc,
+		(SELECT Count(*) FROM sys.availability_replicas ar WHERE ar.group_id = ag.group_id) AS replica_count,
+		(SELECT Count(*) FROM sys.availability_databases_cluster adc WHERE adc.group_id = ag.group_id) AS database_count,
+		c.cluster_name
+		FROM sys.availability_groups ag
+		LEFT JOIN sys.dm_hadr_availability_group_states ags ON ags.group_id = ag.group_id
+		CROSS JOIN (` + clusterNameQuery + `) c
+		WHERE SERVERPROPERTY('IsHadrEnabled') = 1`,
+	dataModels: &[]availabilityGroupModel{},
+	minVersion: clusterTypeMinVersion,
+	editions:   serverEditions,
+	variants: []*QueryDefinition{
+		{
+			query: `SELECT
+			ag.name AS ag_name,
+			ags.primary_replica,
+			ags.synchronization_health_desc,
+			ags.synchronization_health,
+			(SELECT Count(*) FROM sys.availability_replicas ar WHERE ar.group_id = ag.group_id) AS replica_count,
+			(SELECT Count(*) FROM sys.availability_databases_cluster adc WHERE adc.group_id = ag.group_id) AS database_count,
+			c.cluster_name
+			FROM sys.availability_groups ag
+			LEFT JOIN sys.dm_hadr_availability_group_states ags ON ags.group_id = ag.group_id
+			CROSS JOIN (` + clusterNameQuery + `) c
+			WHERE SERVERPROPERTY('IsHadrEnabled') = 1`,
+			dataModels: &[]availabilityGroupModel{},
+			minVersion: availabilityGroupMinVersion,
+			editions:   serverEditions,
+		},
+	},
+}
+
+// availabilityReplicaDefinition gets the state of every replica of the availability groups. The primary
+// replica sees all of them, a secondary replica only itself. The estimated data loss is the time since
+// the last commit of the primary not yet hardened in the replica, and the estimated recovery time the
+// time needed to redo the log in the redo queue.
+var availabilityReplicaDefinition = &QueryDefinition{
+	name: "availability_replicas",
+	query: `SELECT
+		ag.name AS ag_name,
+		ar.replica_server_name,
+		ars.role_desc,
+		ar.availability_mode_desc,
+		ar.failover_mode_desc,
+		ars.connected_state_desc,
+		ars.synchronization_health_desc,
+		ars.synchronization_health,
+		CAST(ars.is_local AS int) AS is_local,
+		CASE WHEN ars.role = 1 THEN 1 ELSE 0 END AS is_primary,
+		CASE WHEN Count(DISTINCT drs.synchronization_state) = 1 THEN Max(drs.synchronization_state_desc) END AS synchronization_state_desc,
+		Count(drs.group_database_id) AS database_count,
+		Sum(CASE WHEN drs.synchronization_state = 2 THEN 1 ELSE 0 END) AS synchronized_database_count,
+		Sum(CAST(drs.log_send_queue_size AS bigint)) * 1024 AS log_send_queue_bytes,
+		Sum(CAST(drs.log_send_rate AS bigint)) * 1024 AS log_send_rate_bytes,
+		Sum(CAST(drs.redo_queue_size AS bigint)) * 1024 AS redo_queue_bytes,
+		Sum(CAST(drs.redo_rate AS bigint)) * 1024 AS redo_rate_bytes,
+		Max(DATEDIFF(SECOND, drs.last_commit_time, pdrs.last_commit_time)) AS estimated_data_loss_seconds,
+		Max(CASE WHEN drs.redo_rate > 0 THEN CAST(drs.redo_queue_size AS float) / drs.redo_rate ELSE 0 END) AS estimated_recovery_time_seconds,
+		c.cluster_name
+		FROM sys.availability_replicas ar
+		INNER JOIN sys.availability_groups ag ON ag.group_id = ar.group_id
+		LEFT JOIN sys.dm_hadr_availability_replica_states ars ON ars.replica_id = ar.replica_id
+		LEFT JOIN sys.dm_hadr_database_replica_states drs ON drs.replica_id = ar.replica_id
+		LEFT JOIN (sys.dm_hadr_database_replica_states pdrs
+			INNER JOIN sys.dm_hadr_availability_replica_states pars ON pars.replica_id = pdrs.replica_id AND pars.role = 1
+		) ON pdrs.group_database_id = drs.group_database_id
+		CROSS JOIN (` + clusterNameQuery + `) c
+		WHERE SERVERPROPERTY('IsHadrEnabled') = 1
+		GROUP BY c.cluster_name, ag.name, ar.replica_server_name, ars.role_desc, ars.role, ar.availability_mode_desc, ar.failover_mode_desc,
+			ars.connected_state_desc, ars.synchronization_health_desc, ars.synchronization_health, ars.is_local`,
+	dataModels: &[]availabilityReplicaModel{},
+	minVersion: availabilityGroupMinVersion,
+	editions:   serverEditions,
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func Test_populateAvailabilityGroupMetrics(t *testing.T) {
+	i, _ := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+	conn.ServerInfo = &connection.ServerInfo{MajorVersion: 15, EngineEdition: connection.EngineEditionEnterprise, Platform: connection.PlatformWindows}
+
+	mock.ExpectQuery(`SELECT\s+ag\.name AS ag_name,\s+ags\.primary_replica.*ag\.cluster_type_desc`).
+		WillReturnRows(sqlmock.NewRows([]string{"ag_name", "primary_replica", "synchronization_health_desc", "synchronization_health", "cluster_type_desc", "replica_count", "database_count", "cluster_name"}).
+			AddRow("ag1", "sql01", "HEALTHY", 2, "WSFC", 2, 3, "wsfc01"))
+	mock.ExpectQuery(`SELECT\s+ag\.name AS ag_name,\s+ar\.replica_server_name`).
+		WillReturnRows(sqlmock.NewRows([]string{"ag_name", "replica_server_name", "role_desc", "availability_mode_desc", "failover_mode_desc", "connected_state_desc", "synchronization_health_desc", "synchronization_health", "is_local", "is_primary", "synchronization_state_desc", "database_count", "synchronized_database_count", "log_send_queue_bytes", "log_send_rate_bytes", "redo_queue_bytes", "redo_rate_bytes", "estimated_data_loss_seconds", "estimated_recovery_time_seconds", "cluster_name"}).
+			AddRow("ag1", "sql01", "PRIMARY", "SYNCHRONOUS_COMMIT", "AUTOMATIC", "CONNECTED", "HEALTHY", 2, 1, 1, "SYNCHRONIZED", 3, 3, 0, 0, 0, 0, 0, 0.0, "wsfc01").
+			AddRow("ag1", "sql02", "SECONDARY", "ASYNCHRONOUS_COMMIT", "MANUAL", "CONNECTED", "PARTIALLY_HEALTHY", 1, 0, 0, nil, 3, 2, 4096, 2048, 8192, 1024, 12, 8.0, "wsfc01"))
+
+	telemetry := NewTelemetry()
+	PopulateAvailabilityGroupMetrics(i, "MSSQL", conn, args.ArgumentList{EnableAvailabilityGroupMetrics: true}, telemetry)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// the test instance entity, the availability group and its two replicas
+	assert.Len(t, i.Entities, 4)
+
+	group := i.Entities[1]
+	assert.Equal(t, "ms-availability-group", group.Metadata.Namespace)
+	assert.Equal(t, "ag1", group.Metadata.Name)
+	assert.Equal(t, "cluster", group.Metadata.IDAttrs[0].Key)
+	assert.Equal(t, "wsfc01", group.Metadata.IDAttrs[0].Value)
+	groupMetrics := group.Metrics[0].Metrics
+	assert.Equal(t, "MssqlAvailabilityGroupSample", groupMetrics["event_type"])
+	assert.Equal(t, "sql01", groupMetrics["ag.primaryReplica"])
+	assert.Equal(t, "WSFC", groupMetrics["ag.clusterType"])
+	assert.Equal(t, float64(2), groupMetrics["ag.synchronizationHealth"])
+	assert.Equal(t, float64(3), groupMetrics["ag.databases"])
+
+	secondary := i.Entities[3]
+	assert.Equal(t, "ms-availability-replica", secondary.Metadata.Namespace)
+	assert.Equal(t, "sql02", secondary.Metadata.Name)
+	assert.Equal(t, "availabilityGroup", secondary.Metadata.IDAttrs[0].Key)
+	assert.Equal(t, "cluster", secondary.Metadata.IDAttrs[1].Key)
+	secondaryMetrics := secondary.Metrics[0].Metrics
+	assert.Equal(t, "MssqlAvailabilityReplicaSample", secondaryMetrics["event_type"])
+	assert.Equal(t, "MSSQL", secondaryMetrics["instance"])
+	assert.Equal(t, "ag1", secondaryMetrics["availabilityGroup"])
+	assert.Equal(t, "wsfc01", secondaryMetrics["cluster"])
+	assert.Equal(t, "SECONDARY", secondaryMetrics["replica.role"])
+	assert.Equal(t, float64(4096), secondaryMetrics["replica.logSendQueueInBytes"])
+	assert.Equal(t, float64(1024), secondaryMetrics["replica.redoRateInBytesPerSecond"])
+	assert.Equal(t, float64(12), secondaryMetrics["replica.estimatedDataLossInSeconds"])
+	assert.Equal(t, float64(8), secondaryMetrics["replica.estimatedRecoveryTimeInSeconds"])
+	assert.NotContains(t, secondaryMetrics, "replica.synchronizationState")
+
+	assert.Equal(t, 2, telemetry.queries["availability_replicas"].rows)
+}
+
+func Test_populateAvailabilityGroupMetrics_Unsupported(t *testing.T) {
+	i, _ := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+	conn.ServerInfo = &connection.ServerInfo{MajorVersion: 12, EngineEdition: connection.EngineEditionAzureSQLDatabase, Platform: connection.PlatformAzure}
+
+	telemetry := NewTelemetry()
+	PopulateAvailabilityGroupMetrics(i, "MSSQL", conn, args.ArgumentList{EnableAvailabilityGroupMetrics: true}, telemetry)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Len(t, i.Entities, 1)
+	assert.Equal(t, queryStatusUnsupported, telemetry.queries["availability_groups"].status)
+	assert.Equal(t, queryStatusUnsupported, telemetry.queries["availability_replicas"].status)
+}
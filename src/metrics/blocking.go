@@ -0,0 +1,195 @@
+package metrics
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/obfuscation"
+)
+
+// blockingChain is a head blocker and the sessions it blocks, directly or through other sessions
+type blockingChain struct {
+	headID int64
+	// head is nil if the head blocker is not a user session, Ex: -2 for orphaned distributed transactions
+	head            *blockingSessionModel
+	blockedSessions int
+	depth           int
+	longestWait     int64
+	longestWaitType string
+}
+
+// PopulateBlockingMetrics reports an MssqlBlockingChainSample for every chain of blocked sessions, describing
+// the head blocker that causes it
+func PopulateBlockingMetrics(instanceEntity *integration.Entity, con *connection.SQLConnection, arguments args.ArgumentList, telemetry *Telemetry) {
+	if !arguments.EnableBlockingMetrics {
+		telemetry.recordDisabled(blockingSessionsDefinition)
+		return
+	}
+
+	if err := con.Err(); err != nil {
+		log.Warn("Skipping blocking queries: %s", err.Error())
+		telemetry.recordSkipped(blockingSessionsDefinition)
+		return
+	}
+
+	queryDef, ok := blockingSessionsDefinition.forServer(con.ServerInfo)
+	if !ok {
+		telemetry.recordUnsupported(blockingSessionsDefinition)
+		return
+	}
+
+	sessions := make([]blockingSessionModel, 0)
+	if err := runQueryDefinition(con, telemetry, queryDef, queryDef.GetQuery(), &sessions); err != nil {
+		log.Error("Could not execute blocking query: %s", err.Error())
+		return
+	}
+
+	obfuscator, _ := obfuscation.New(arguments.QueryTextMode)
+	for _, chain := range blockingChains(sessions) {
+		populateBlockingChainSample(instanceEntity, con.Host, obfuscator, chain)
+	}
+}
+
+// blockingChains groups the sessions in chains by their head blocker, the session blocking others without
+// being blocked. Sessions blocking each other in a cycle are headed by the lowest session id of the cycle.
+func blockingChains(sessions []blockingSessionModel) []*blockingChain {
+	byID := make(map[int64]*blockingSessionModel, len(sessions))
+	blockerOf := make(map[int64]int64)
+	blocked := make(map[int64][]int64)
+	for index := range sessions {
+		session := &sessions[index]
+		byID[session.SessionID] = session
+		if session.BlockingSessionID != nil {
+			blockerOf[session.SessionID] = *session.BlockingSessionID
+			blocked[*session.BlockingSessionID] = append(blocked[*session.BlockingSessionID], session.SessionID)
+		}
+	}
+
+	heads := make(map[int64]bool)
+	for blocker := range blocked {
+		heads[headBlocker(blocker, blockerOf)] = true
+	}
+
+	chains := make([]*blockingChain, 0, len(heads))
+	for headID := range heads {
+		chain := &blockingChain{headID: headID, head: byID[headID]}
+
+		// walk the sessions blocked by the head, level by level
+		visited := map[int64]bool{headID: true}
+		level := []int64{headID}
+		for len(level) > 0 {
+			next := make([]int64, 0)
+			for _, id := range level {
+				for _, blockedID := range blocked[id] {
+					if visited[blockedID] {
+						continue
+					}
+					visited[blockedID] = true
+					next = append(next, blockedID)
+					chain.blockedSessions++
+
+					if session := byID[blockedID]; session != nil && session.WaitTime != nil && *session.WaitTime > chain.longestWait {
+						chain.longestWait = *session.WaitTime
+						if session.WaitType != nil {
+							chain.longestWaitType = *session.WaitType
+						}
+					}
+				}
+			}
+			if len(next) > 0 {
+				chain.depth++
+			}
+			level = next
+		}
+
+		chains = append(chains, chain)
+	}
+
+	sort.Slice(chains, func(a, b int) bool {
+		if chains[a].blockedSessions != chains[b].blockedSessions {
+			return chains[a].blockedSessions > chains[b].blockedSessions
+		}
+		return chains[a].headID < chains[b].headID
+	})
+
+	return chains
+}
+
+// headBlocker follows the blockers of id up to the session not blocked by any other
+func headBlocker(id int64, blockerOf map[int64]int64) int64 {
+	visited := map[int64]bool{}
+	for {
+		visited[id] = true
+		blocker, ok := blockerOf[id]
+		if !ok {
+			return id
+		}
+		if visited[blocker] {
+			// a cycle, headed by its lowest session id
+			head := blocker
+			for current := blockerOf[blocker]; current != blocker; current = blockerOf[current] {
+				if current < head {
+					head = current
+				}
+			}
+			return head
+		}
+		id = blocker
+	}
+}
+
+func populateBlockingChainSample(instanceEntity *integration.Entity, host string, obfuscator *obfuscation.Obfuscator, chain *blockingChain) {
+	attributes := []attribute.Attribute{
+		{Key: "displayName", Value: instanceEntity.Metadata.Name},
+		{Key: "entityName", Value: instanceEntity.Metadata.Namespace + ":" + instanceEntity.Metadata.Name},
+		{Key: "host", Value: host},
+		{Key: "instance", Value: instanceEntity.Metadata.Name},
+		{Key: "headBlockerSessionId", Value: strconv.FormatInt(chain.headID, 10)},
+	}
+	if chain.longestWaitType != "" {
+		attributes = append(attributes, attribute.Attribute{Key: "longestWaitType", Value: chain.longestWaitType})
+	}
+	if head := chain.head; head != nil {
+		for _, a := range []struct {
+			key   string
+			value *string
+		}{
+			{"headBlockerLogin", head.LoginName},
+			{"headBlockerHost", head.HostName},
+			{"headBlockerProgram", head.ProgramName},
+			{"headBlockerDatabase", head.DatabaseName},
+			{"headBlockerStatus", head.Status},
+			{"headBlockerWaitType", head.WaitType},
+		} {
+			if a.value != nil && *a.value != "" {
+				attributes = append(attributes, attribute.Attribute{Key: a.key, Value: *a.value})
+			}
+		}
+		if head.StatementText != nil {
+			if text, ok := obfuscator.Apply(*head.StatementText); ok {
+				attributes = append(attributes, attribute.Attribute{Key: "headBlockerStatement", Value: truncateQueryText(text)})
+			}
+		}
+	}
+	metricSet := instanceEntity.NewMetricSet("MssqlBlockingChainSample", attributes...)
+
+	metrics := map[string]int64{
+		"blocking.depth":                     int64(chain.depth),
+		"blocking.blockedSessions":           int64(chain.blockedSessions),
+		"blocking.longestWaitInMilliseconds": chain.longestWait,
+	}
+	if chain.head != nil && chain.head.TransactionCount != nil {
+		metrics["blocking.headBlockerOpenTransactions"] = *chain.head.TransactionCount
+	}
+	for name, value := range metrics {
+		if err := metricSet.SetMetric(name, value, metric.GAUGE); err != nil {
+			log.Error("Could not set blocking metric '%s': %s", name, err.Error())
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package metrics
+
+// blockingSessionModel is a session blocked by or blocking another one
+type blockingSessionModel struct {
+	SessionID         int64   `db:"session_id"`
+	BlockingSessionID *int64  `db:"blocking_session_id"`
+	WaitType          *string `db:"wait_type"`
+	WaitTime          *int64  `db:"wait_time_ms"`
+	WaitResource      *string `db:"wait_resource"`
+	Status            *string `db:"status"`
+	LoginName         *string `db:"login_name"`
+	HostName          *string `db:"host_name"`
+	ProgramName       *string `db:"program_name"`
+	DatabaseName      *string `db:"database_name"`
+	TransactionCount  *int64  `db:"open_transaction_count"`
+	StatementText     *string `db:"statement_text"`
+}
+
+// blockingSessionsDefinition gets the sessions taking part in blocking. The blocker of a request is the one of
+// the request, or the one of its tasks for parallel queries. Head blockers may be idle sessions holding locks,
+// whose statement is the last one they ran.
+var blockingSessionsDefinition = &QueryDefinition{
+	name: "blocking_sessions",
+	query: `WITH blocked AS (
+		SELECT r.session_id, r.blocking_session_id FROM sys.dm_exec_requests r WITH (NOLOCK)
+		WHERE r.blocking_session_id <> 0 AND r.blocking_session_id <> r.session_id
+		UNION
+		SELECT wt.session_id, wt.blocking_session_id FROM sys.dm_os_waiting_tasks wt WITH (NOLOCK)
+		WHERE wt.blocking_session_id IS NOT NULL AND wt.blocking_session_id <> wt.session_id
+	)
+	SELECT
+		s.session_id,
+		(SELECT Min(b.blocking_session_id) FROM blocked b WHERE b.session_id = s.session_id) AS blocking_session_id,
+		r.wait_type,
+		r.wait_time AS wait_time_ms,
+		r.wait_resource,
+		COALESCE(r.status, s.status) AS status,
+		s.login_name,
+		s.host_name,
+		s.program_name,
+		DB_NAME(COALESCE(r.database_id, s.database_id)) AS database_name,
+		s.open_transaction_count,
+		CASE WHEN r.sql_handle IS NOT NULL THEN
+			SUBSTRING(t.text, r.statement_start_offset / 2 + 1,
+				(CASE r.statement_end_offset WHEN -1 THEN DATALENGTH(t.text) ELSE r.statement_end_offset END - r.statement_start_offset) / 2 + 1)
+			ELSE t.text END AS statement_text
+		FROM sys.dm_exec_sessions s WITH (NOLOCK)
+		LEFT JOIN sys.dm_exec_requests r WITH (NOLOCK) ON r.session_id = s.session_id
+		LEFT JOIN sys.dm_exec_connections c WITH (NOLOCK) ON c.session_id = s.session_id
+		OUTER APPLY sys.dm_exec_sql_text(COALESCE(r.sql_handle, c.most_recent_sql_handle)) t
+		WHERE s.session_id IN (SELECT session_id FROM blocked UNION SELECT blocking_session_id FROM blocked)`,
+	dataModels: &[]blockingSessionModel{},
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func blockedSession(id, blocker, waitTime int64) blockingSessionModel {
+	waitType := "LCK_M_X"
+	return blockingSessionModel{SessionID: id, BlockingSessionID: &blocker, WaitTime: &waitTime, WaitType: &waitType}
+}
+
+func Test_blockingChains(t *testing.T) {
+	sessions := []blockingSessionModel{
+		// 51 blocks 52 and 53, 53 blocks 54
+		{SessionID: 51},
+		blockedSession(52, 51, 1000),
+		blockedSession(53, 51, 3000),
+		blockedSession(54, 53, 2000),
+		// 60 is blocked by an orphaned distributed transaction
+		blockedSession(60, -2, 500),
+		// 70 and 71 block each other, 72 waits for 71
+		blockedSession(71, 70, 100),
+		blockedSession(70, 71, 200),
+		blockedSession(72, 71, 50),
+	}
+
+	chains := blockingChains(sessions)
+	assert.Len(t, chains, 3)
+
+	assert.Equal(t, int64(51), chains[0].headID)
+	assert.NotNil(t, chains[0].head)
+	assert.Equal(t, 3, chains[0].blockedSessions)
+	assert.Equal(t, 2, chains[0].depth)
+	assert.Equal(t, int64(3000), chains[0].longestWait)
+	assert.Equal(t, "LCK_M_X", chains[0].longestWaitType)
+
+	assert.Equal(t, int64(70), chains[1].headID)
+	assert.Equal(t, 2, chains[1].blockedSessions)
+
+	assert.Equal(t, int64(-2), chains[2].headID)
+	assert.Nil(t, chains[2].head)
+	assert.Equal(t, 1, chains[2].depth)
+}
+
+func Test_populateBlockingMetrics(t *testing.T) {
+	_, e := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	mock.ExpectQuery(`WITH blocked AS`).
+		WillReturnRows(sqlmock.NewRows([]string{"session_id", "blocking_session_id", "wait_type", "wait_time_ms", "wait_resource", "status", "login_name", "host_name", "program_name", "database_name", "open_transaction_count", "statement_text"}).
+			AddRow(51, nil, nil, nil, nil, "sleeping", "app", "web01", "billing", "sales", 1, "UPDATE accounts SET balance = 10 WHERE id = 7").
+			AddRow(52, 51, "LCK_M_U", 4500, "KEY: 5:72057594043760640 (8194443284a0)", "suspended", "report", "bi01", "ssrs", "sales", 0, "SELECT * FROM accounts"))
+
+	PopulateBlockingMetrics(e, conn, args.ArgumentList{EnableBlockingMetrics: true}, nil)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Len(t, e.Metrics, 1)
+	chain := e.Metrics[0].Metrics
+	assert.Equal(t, "MssqlBlockingChainSample", chain["event_type"])
+	assert.Equal(t, "51", chain["headBlockerSessionId"])
+	assert.Equal(t, "app", chain["headBlockerLogin"])
+	assert.Equal(t, "web01", chain["headBlockerHost"])
+	assert.Equal(t, "billing", chain["headBlockerProgram"])
+	assert.Equal(t, "sleeping", chain["headBlockerStatus"])
+	assert.Equal(t, "UPDATE accounts SET balance = ? WHERE id = ?", chain["headBlockerStatement"])
+	assert.Equal(t, "LCK_M_U", chain["longestWaitType"])
+	assert.Equal(t, float64(1), chain["blocking.depth"])
+	assert.Equal(t, float64(1), chain["blocking.blockedSessions"])
+	assert.Equal(t, float64(4500), chain["blocking.longestWaitInMilliseconds"])
+	assert.Equal(t, float64(1), chain["blocking.headBlockerOpenTransactions"])
+}
@@ -18,9 +18,18 @@ func dbNameReplace(dbName string) QueryModifier {
 	}
 }
 
+// excludedDatabasesReplace inserts the list of databases excluded by filter into a query
+// anywhere database.ExcludedDatabasesPlaceHolder is present
+func excludedDatabasesReplace(filter *database.NameFilter) QueryModifier {
+	return func(query string) string {
+		return strings.Replace(query, database.ExcludedDatabasesPlaceHolder, filter.ExclusionList(), -1)
+	}
+}
+
 // databaseDefinitions definitions for Database Queries
 var databaseDefinitions = []*QueryDefinition{
 	{
+		name: "database_log_growth",
 		query: `select
 		RTRIM(t1.instance_name) as db_name,
 		t1.cntr_value as log_growth
@@ -28,48 +37,97 @@ var databaseDefinitions = []*QueryDefinition{
       SELECT * FROM sys.dm_os_performance_counters WITH (NOLOCK)
       WHERE object_name = 'SQLServer:Databases'
         AND counter_name = 'Log Growths'
-        AND RTRIM(instance_name) NOT IN ('master', 'tempdb', 'msdb', 'model', 'rdsadmin', 'distribution', 'model_msdb', 'model_replicatedmaster')
-        AND instance_name NOT IN ('_Total', 'mssqlsystemresource', 'master', 'tempdb', 'msdb', 'model', 'rdsadmin', 'distribution', 'model_msdb', 'model_replicatedmaster')
+        AND RTRIM(instance_name) NOT IN ('_Total', 'mssqlsystemresource', ` + database.ExcludedDatabasesPlaceHolder + `)
     ) t1
     `,
 		dataModels: &[]struct {
 			database.DataModel
 			LogGrowth int `db:"log_growth" metric_name:"log.transactionGrowth" source_type:"gauge"`
 		}{},
+		editions: serverEditions,
 	}, {
+		name: "database_io_stalls",
 		query: `select
 		DB_NAME(database_id) AS db_name,
 		SUM(io_stall_write_ms) + SUM(num_of_writes) as io_stalls
 		FROM sys.dm_io_virtual_file_stats(null,null)
-    WHERE DB_NAME(database_id) NOT IN ('master', 'tempdb', 'msdb', 'model', 'rdsadmin', 'distribution', 'model_msdb', 'model_replicatedmaster')
+    WHERE DB_NAME(database_id) NOT IN (` + database.ExcludedDatabasesPlaceHolder + `)
 		GROUP BY database_id`,
 		dataModels: &[]struct {
 			database.DataModel
 			IOStalls int `db:"io_stalls" metric_name:"io.stallInMilliseconds" source_type:"gauge"`
 		}{},
+		editions: serverEditions,
+	},
+}
+
+// databaseBackupDefinitions definitions for the backup history of each database. The ages are the hours since the
+// last backup of each type finished, the size and duration the ones of the last backup of any type.
+// tempdb (database_id 2) cannot be backed up.
+var databaseBackupDefinitions = []*QueryDefinition{
+	{
+		name: "database_backups",
+		query: `SELECT
+		d.name AS db_name,
+		d.recovery_model_desc,
+		DATEDIFF(MINUTE, b.last_full, GETDATE()) / 60.0 AS last_full_age_hours,
+		DATEDIFF(MINUTE, b.last_differential, GETDATE()) / 60.0 AS last_differential_age_hours,
+		DATEDIFF(MINUTE, b.last_log, GETDATE()) / 60.0 AS last_log_age_hours,
+		lb.backup_size AS last_backup_size,
+		lb.compressed_backup_size AS last_backup_compressed_size,
+		DATEDIFF(SECOND, lb.backup_start_date, lb.backup_finish_date) AS last_backup_duration_seconds,
+		CASE WHEN b.last_full IS NULL THEN 1 ELSE 0 END AS missing_full,
+		CASE WHEN b.last_log IS NULL AND d.recovery_model_desc <> 'SIMPLE' THEN 1 ELSE 0 END AS missing_log
+		FROM sys.databases d
+		LEFT JOIN (
+			SELECT database_name,
+			Max(CASE WHEN type = 'D' THEN backup_finish_date END) AS last_full,
+			Max(CASE WHEN type = 'I' THEN backup_finish_date END) AS last_differential,
+			Max(CASE WHEN type = 'L' THEN backup_finish_date END) AS last_log
+			FROM msdb.dbo.backupset
+			GROUP BY database_name
+		) b ON b.database_name = d.name
+		OUTER APPLY (
+			SELECT TOP 1 bs.backup_size, bs.compressed_backup_size, bs.backup_start_date, bs.backup_finish_date
+			FROM msdb.dbo.backupset bs WHERE bs.database_name = d.name ORDER BY bs.backup_finish_date DESC
+		) lb
+		WHERE d.database_id <> 2 AND d.name NOT IN (` + database.ExcludedDatabasesPlaceHolder + `)`,
+		dataModels: &[]struct {
+			database.DataModel
+			RecoveryModel        *string  `db:"recovery_model_desc" metric_name:"recoveryModel" source_type:"attribute"`
+			LastFullAge          *float64 `db:"last_full_age_hours" metric_name:"backup.lastFullAgeInHours" source_type:"gauge"`
+			LastDifferentialAge  *float64 `db:"last_differential_age_hours" metric_name:"backup.lastDifferentialAgeInHours" source_type:"gauge"`
+			LastLogAge           *float64 `db:"last_log_age_hours" metric_name:"backup.lastLogAgeInHours" source_type:"gauge"`
+			LastBackupSize       *int64   `db:"last_backup_size" metric_name:"backup.lastSizeInBytes" source_type:"gauge"`
+			LastBackupCompressed *int64   `db:"last_backup_compressed_size" metric_name:"backup.lastCompressedSizeInBytes" source_type:"gauge"`
+			LastBackupDuration   *int64   `db:"last_backup_duration_seconds" metric_name:"backup.lastDurationInSeconds" source_type:"gauge"`
+			MissingFullBackup    *int64   `db:"missing_full" metric_name:"backup.missingFull" source_type:"gauge"`
+			MissingLogBackup     *int64   `db:"missing_log" metric_name:"backup.missingLog" source_type:"gauge"`
+		}{},
+		editions: serverEditions,
 	},
 }
 
 // databaseBufferDefinitions definitions for Database Queries
 var databaseBufferDefinitions = []*QueryDefinition{
 	{
+		name: "database_buffer_pool_size",
 		query: `SELECT DB_NAME(database_id) AS db_name, buffer_pool_size * (8*1024) AS buffer_pool_size
 		FROM ( SELECT database_id, COUNT_BIG(*) AS buffer_pool_size FROM sys.dm_os_buffer_descriptors a WITH (NOLOCK)
 		INNER JOIN sys.sysdatabases b WITH (NOLOCK) ON b.dbid=a.database_id 
 		WHERE b.dbid in (SELECT dbid FROM sys.sysdatabases WITH (NOLOCK)
-		WHERE name NOT IN ('master', 'tempdb', 'msdb', 'model', 'rdsadmin', 'distribution', 'model_msdb', 'model_replicatedmaster')
+		WHERE name NOT IN (` + database.ExcludedDatabasesPlaceHolder + `)
 		UNION ALL SELECT 32767) GROUP BY database_id) a`,
 		dataModels: &[]struct {
 			database.DataModel
 			IOStalls int `db:"buffer_pool_size" metric_name:"bufferpool.sizePerDatabaseInBytes" source_type:"gauge"`
 		}{},
+		editions: serverEditions,
 	},
 }
 
-var specificDatabaseDefinitions = []*QueryDefinition{
-	{
-		query: fmt.Sprintf(`USE "%s"
-		;WITH reserved_space(db_name, reserved_space_kb, reserved_space_not_used_kb)
+// reservedSpaceQuery gets the reserved space of the database the query runs in
+const reservedSpaceQuery = `WITH reserved_space(db_name, reserved_space_kb, reserved_space_not_used_kb)
 		AS
 		(
 		SELECT
@@ -85,11 +143,61 @@ var specificDatabaseDefinitions = []*QueryDefinition{
 		max(reserved_space_kb) * 1024 AS reserved_space,
 		max(reserved_space_not_used_kb) * 1024 AS reserved_space_not_used
 		FROM reserved_space
-		GROUP BY db_name`, databasePlaceHolder),
+		GROUP BY db_name`
+
+var reservedSpaceDataModels = &[]struct {
+	database.DataModel
+	ReservedSpace        float64 `db:"reserved_space" metric_name:"pageFileTotal" source_type:"gauge"`
+	ReservedSpaceNotUsed float64 `db:"reserved_space_not_used" metric_name:"pageFileAvailable" source_type:"gauge"`
+}{}
+
+var specificDatabaseDefinitions = []*QueryDefinition{
+	{
+		name: "database_reserve_space",
+		query: fmt.Sprintf(`USE "%s"
+		;`, databasePlaceHolder) + reservedSpaceQuery,
+		dataModels: reservedSpaceDataModels,
+		editions:   serverEditions,
+	},
+}
+
+// azureDatabaseDefinitions run in a connection to each Azure SQL Database, which cannot switch databases with USE
+var azureDatabaseDefinitions = []*QueryDefinition{
+	{
+		name: "azure_database_resource_stats",
+		query: `SELECT TOP 1
+		DB_NAME() AS db_name,
+		avg_cpu_percent,
+		avg_data_io_percent,
+		avg_log_write_percent,
+		avg_memory_usage_percent,
+		max_worker_percent,
+		max_session_percent,
+		dtu_limit,
+		cpu_limit
+		FROM sys.dm_db_resource_stats
+		ORDER BY end_time DESC`,
 		dataModels: &[]struct {
 			database.DataModel
-			ReservedSpace        float64 `db:"reserved_space" metric_name:"pageFileTotal" source_type:"gauge"`
-			ReservedSpaceNotUsed float64 `db:"reserved_space_not_used" metric_name:"pageFileAvailable" source_type:"gauge"`
+			CPUPercent         *float64 `db:"avg_cpu_percent" metric_name:"resource.cpuPercent" source_type:"gauge"`
+			DataIOPercent      *float64 `db:"avg_data_io_percent" metric_name:"resource.dataIOPercent" source_type:"gauge"`
+			LogWritePercent    *float64 `db:"avg_log_write_percent" metric_name:"resource.logWritePercent" source_type:"gauge"`
+			MemoryUsagePercent *float64 `db:"avg_memory_usage_percent" metric_name:"resource.memoryUsagePercent" source_type:"gauge"`
+			WorkersPercent     *float64 `db:"max_worker_percent" metric_name:"resource.workersPercent" source_type:"gauge"`
+			SessionsPercent    *float64 `db:"max_session_percent" metric_name:"resource.sessionsPercent" source_type:"gauge"`
+			DTULimit           *int64   `db:"dtu_limit" metric_name:"resource.dtuLimit" source_type:"gauge"`
+			CPULimit           *float64 `db:"cpu_limit" metric_name:"resource.vCoreLimit" source_type:"gauge"`
 		}{},
+		editions: azureSQLDatabaseEditions,
+	},
+}
+
+// azureDatabaseReserveDefinitions run in a connection to each Azure SQL Database when reserve metrics are enabled
+var azureDatabaseReserveDefinitions = []*QueryDefinition{
+	{
+		name:       "azure_database_reserve_space",
+		query:      reservedSpaceQuery,
+		dataModels: reservedSpaceDataModels,
+		editions:   azureSQLDatabaseEditions,
 	},
 }
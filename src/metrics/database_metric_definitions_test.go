@@ -2,7 +2,14 @@ package metrics
 
 import (
 	"fmt"
+	"strings"
 	"testing"
+
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/database"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
 )
 
 func Test_dbNameReplace(t *testing.T) {
@@ -16,3 +23,64 @@ func Test_dbNameReplace(t *testing.T) {
 		t.Errorf("Expected '%s' got '%s'", expected, out)
 	}
 }
+
+func Test_excludedDatabasesReplace(t *testing.T) {
+	query := "select * from sys.databases where name not in (" + database.ExcludedDatabasesPlaceHolder + ")"
+
+	filter, err := database.NewNameFilter("", "", false)
+	assert.NoError(t, err)
+	out := excludedDatabasesReplace(filter)(query)
+	assert.Contains(t, out, "'master', 'tempdb', 'msdb', 'model'")
+	assert.NotContains(t, out, database.ExcludedDatabasesPlaceHolder)
+
+	filter, err = database.NewNameFilter("", "", true)
+	assert.NoError(t, err)
+	out = excludedDatabasesReplace(filter)(query)
+	assert.NotContains(t, out, "'master'")
+	assert.Contains(t, out, "'rdsadmin'")
+}
+
+func Test_databaseDefinitions_NoHardcodedExclusions(t *testing.T) {
+	definitions := append(append([]*QueryDefinition{}, databaseDefinitions...), databaseBufferDefinitions...)
+	definitions = append(definitions, databaseBackupDefinitions...)
+	for _, def := range definitions {
+		assert.True(t, strings.Contains(def.GetQuery(), database.ExcludedDatabasesPlaceHolder))
+		assert.NotContains(t, def.GetQuery(), "'tempdb'")
+	}
+}
+
+func Test_populateDatabaseMetrics_Backups(t *testing.T) {
+	i, _ := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	mock.ExpectQuery(`select name as db_name from sys\.databases`).
+		WillReturnRows(sqlmock.NewRows([]string{"db_name"}).AddRow("sales").AddRow("staging"))
+	mock.ExpectQuery(`FROM msdb\.dbo\.backupset`).
+		WillReturnRows(sqlmock.NewRows([]string{"db_name", "recovery_model_desc", "last_full_age_hours", "last_differential_age_hours", "last_log_age_hours", "last_backup_size", "last_backup_compressed_size", "last_backup_duration_seconds", "missing_full", "missing_log"}).
+			AddRow("sales", "FULL", 30.5, 6.25, nil, 1048576, 524288, 42, 0, 1).
+			AddRow("staging", "SIMPLE", nil, nil, nil, nil, nil, nil, 1, 0))
+
+	telemetry := NewTelemetry()
+	_, err := PopulateDatabaseMetrics(i, "MSSQL", conn, args.ArgumentList{EnableDatabaseBackupMetrics: true}, telemetry)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	sales := i.Entities[1].Metrics[0].Metrics
+	assert.Equal(t, "FULL", sales["recoveryModel"])
+	assert.Equal(t, 30.5, sales["backup.lastFullAgeInHours"])
+	assert.Equal(t, 6.25, sales["backup.lastDifferentialAgeInHours"])
+	assert.NotContains(t, sales, "backup.lastLogAgeInHours")
+	assert.Equal(t, float64(1048576), sales["backup.lastSizeInBytes"])
+	assert.Equal(t, float64(42), sales["backup.lastDurationInSeconds"])
+	assert.Equal(t, float64(1), sales["backup.missingLog"])
+
+	staging := i.Entities[2].Metrics[0].Metrics
+	assert.Equal(t, "SIMPLE", staging["recoveryModel"])
+	assert.Equal(t, float64(1), staging["backup.missingFull"])
+	assert.Equal(t, float64(0), staging["backup.missingLog"])
+
+	assert.Equal(t, 2, telemetry.queries["database_backups"].rows)
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"strings"
+	"time"
+)
+
+// deadlockSincePlaceHolder is replaced with the time of the last deadlock reported
+const deadlockSincePlaceHolder = "%SINCE%"
+
+// deadlocksFirstSince are the deadlocks reported by the first collection, the ones of the last hour
+const deadlocksFirstSince = "DATEADD(HOUR, -1, SYSUTCDATETIME())"
+
+// deadlockEventModel is an xml_deadlock_report event, with its time in UTC
+type deadlockEventModel struct {
+	EventTime   time.Time `db:"event_time"`
+	DeadlockXML string    `db:"deadlock_xml"`
+}
+
+// deadlockFileDefinition reads the deadlocks from the event files of the system_health session. Up to 100
+// deadlocks are read in a collection, the rest are read by the next ones. The deadlocks at SINCE are read
+// again, as more deadlocks may share the time of the last one reported. Since SQL Server 2017 the events
+// older than SINCE are filtered by their time before parsing them, the files are read anyway.
+var deadlockFileDefinition = &QueryDefinition{
+	name: "deadlocks",
+	query: `SELECT TOP (100) event_time, deadlock_xml FROM (
+		SELECT
+		x.event_data.value('(event/@timestamp)[1]', 'datetime2') AS event_time,
+		CAST(x.event_data.query('event/data[@name="xml_report"]/value/deadlock') AS nvarchar(max)) AS deadlock_xml
+		FROM (
+			SELECT CAST(event_data AS xml) AS event_data FROM sys.fn_xe_file_target_read_file('system_health*.xel', NULL, NULL, NULL)
+			WHERE object_name = 'xml_deadlock_report' AND timestamp_utc >= %SINCE%
+		) x
+	) d
+	WHERE event_time >= %SINCE%
+	ORDER BY event_time`,
+	dataModels: &[]deadlockEventModel{},
+	minVersion: 14,
+	editions:   serverEditions,
+	variants: []*QueryDefinition{
+		{
+			query: `SELECT TOP (100) event_time, deadlock_xml FROM (
+			SELECT
+			x.event_data.value('(event/@timestamp)[1]', 'datetime2') AS event_time,
+			CAST(x.event_data.query('event/data[@name="xml_report"]/value/deadlock') AS nvarchar(max)) AS deadlock_xml
+			FROM (
+				SELECT CAST(event_data AS xml) AS event_data FROM sys.fn_xe_file_target_read_file('system_health*.xel', NULL, NULL, NULL)
+				WHERE object_name = 'xml_deadlock_report'
+			) x
+		) d
+		WHERE event_time >= %SINCE%
+		ORDER BY event_time`,
+			dataModels: &[]deadlockEventModel{},
+			minVersion: 11,
+			editions:   serverEditions,
+		},
+	},
+}
+
+// deadlockRingBufferDefinition reads the deadlocks from the ring buffer of the system_health session, which
+// keeps fewer events than the files
+var deadlockRingBufferDefinition = &QueryDefinition{
+	name: "deadlocks",
+	query: `SELECT TOP (100) event_time, deadlock_xml FROM (
+		SELECT
+		x.event.value('@timestamp', 'datetime2') AS event_time,
+		CAST(x.event.query('data[@name="xml_report"]/value/deadlock') AS nvarchar(max)) AS deadlock_xml
+		FROM (
+			SELECT CAST(t.target_data AS xml) AS target_data FROM sys.dm_xe_session_targets t
+			INNER JOIN sys.dm_xe_sessions s ON s.address = t.event_session_address
+			WHERE s.name = 'system_health' AND t.target_name = 'ring_buffer'
+		) rb
+		CROSS APPLY rb.target_data.nodes('RingBufferTarget/event[@name="xml_deadlock_report"]') AS x(event)
+	) d
+	WHERE event_time >= %SINCE%
+	ORDER BY event_time`,
+	dataModels: &[]deadlockEventModel{},
+	minVersion: 11,
+	editions:   serverEditions,
+}
+
+// deadlockSinceReplace sets the time from which deadlocks are reported
+func deadlockSinceReplace(since *time.Time) QueryModifier {
+	return func(query string) string {
+		value := deadlocksFirstSince
+		if since != nil {
+			value = "'" + since.UTC().Format("2006-01-02T15:04:05.0000000") + "'"
+		}
+		return strings.Replace(query, deadlockSincePlaceHolder, value, -1)
+	}
+}
@@ -0,0 +1,256 @@
+package metrics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/obfuscation"
+	"github.com/newrelic/nri-mssql/src/state"
+)
+
+const (
+	// lastDeadlockKey stores the time of the last deadlock reported
+	lastDeadlockKey = "deadlocks.lastEventTime"
+	// lastDeadlockIDsKey stores the ids of the deadlocks reported with the time of the last one
+	lastDeadlockIDsKey = "deadlocks.lastEventIds"
+)
+
+// deadlockReport is the xml_deadlock_report of a deadlock
+type deadlockReport struct {
+	Victims []struct {
+		ID string `xml:"id,attr"`
+	} `xml:"victim-list>victimProcess"`
+	Processes    []deadlockProcess `xml:"process-list>process"`
+	ResourceList struct {
+		Resources []deadlockResource `xml:",any"`
+	} `xml:"resource-list"`
+}
+
+// deadlockProcess is a process taking part in a deadlock
+type deadlockProcess struct {
+	ID               string `xml:"id,attr"`
+	SPID             string `xml:"spid,attr"`
+	WaitResource     string `xml:"waitresource,attr"`
+	WaitTime         int64  `xml:"waittime,attr"`
+	LockMode         string `xml:"lockMode,attr"`
+	LogUsed          int64  `xml:"logused,attr"`
+	TransactionName  string `xml:"transactionname,attr"`
+	TransactionCount int64  `xml:"trancount,attr"`
+	IsolationLevel   string `xml:"isolationlevel,attr"`
+	Status           string `xml:"status,attr"`
+	ClientApp        string `xml:"clientapp,attr"`
+	HostName         string `xml:"hostname,attr"`
+	LoginName        string `xml:"loginname,attr"`
+	DatabaseName     string `xml:"currentdbname,attr"`
+	Frames           []struct {
+		ProcName string `xml:"procname,attr"`
+		Text     string `xml:",chardata"`
+	} `xml:"executionStack>frame"`
+	InputBuffer string `xml:"inputbuf"`
+}
+
+// deadlockResource is a resource locked by the processes of a deadlock, Ex: a keylock
+type deadlockResource struct {
+	XMLName    xml.Name
+	ObjectName string `xml:"objectname,attr"`
+	IndexName  string `xml:"indexname,attr"`
+	Mode       string `xml:"mode,attr"`
+}
+
+// statement returns the statement a process was running, the one at the top of its stack
+// if known or else its input buffer
+func (p deadlockProcess) statement() string {
+	for _, frame := range p.Frames {
+		if text := strings.TrimSpace(frame.Text); text != "" {
+			return text
+		}
+	}
+	return strings.TrimSpace(p.InputBuffer)
+}
+
+// description returns the type and the object of the resource, Ex: keylock sales.dbo.accounts (PK_accounts)
+func (r deadlockResource) description() string {
+	description := r.XMLName.Local
+	if r.ObjectName != "" {
+		description += " " + r.ObjectName
+	}
+	if r.IndexName != "" {
+		description += " (" + r.IndexName + ")"
+	}
+	return description
+}
+
+// PopulateDeadlockMetrics reports the deadlocks captured by the system_health session since the previous collection,
+// as an MssqlDeadlockSample for each deadlock and an MssqlDeadlockProcessSample for each of its processes. The time
+// of the last deadlock reported is kept in the state of the instance so each one is reported once, along with the ids of
+// the deadlocks with that time, as the ones sharing it are read again. The first collection reports the deadlocks of the last hour.
+func PopulateDeadlockMetrics(instanceEntity *integration.Entity, con *connection.SQLConnection, arguments args.ArgumentList, instanceState *state.State, telemetry *Telemetry) {
+	definition := deadlockFileDefinition
+	if arguments.DeadlockSource == args.DeadlockSourceRingBuffer {
+		definition = deadlockRingBufferDefinition
+	}
+
+	if !arguments.EnableDeadlockMetrics {
+		telemetry.recordDisabled(definition)
+		return
+	}
+
+	if instanceState == nil {
+		telemetry.recordSkipped(definition)
+		return
+	}
+
+	if err := con.Err(); err != nil {
+		log.Warn("Skipping deadlock queries: %s", err.Error())
+		telemetry.recordSkipped(definition)
+		return
+	}
+
+	queryDef, ok := definition.forServer(con.ServerInfo)
+	if !ok {
+		telemetry.recordUnsupported(definition)
+		return
+	}
+
+	var since *time.Time
+	lastEventTime, ok := instanceState.Watermark(lastDeadlockKey)
+	if ok {
+		since = &lastEventTime
+	}
+	lastEventIDs := make(map[string]bool)
+	for _, id := range instanceState.EventIDs(lastDeadlockIDsKey) {
+		lastEventIDs[id] = true
+	}
+
+	events := make([]deadlockEventModel, 0)
+	if err := runQueryDefinition(con, telemetry, queryDef, queryDef.GetQuery(deadlockSinceReplace(since)), &events); err != nil {
+		log.Error("Could not execute deadlock query: %s", err.Error())
+		return
+	}
+
+	obfuscator, _ := obfuscation.New(arguments.QueryTextMode)
+	for _, event := range events {
+		id := deadlockID(event)
+		if event.EventTime.Equal(lastEventTime) {
+			if lastEventIDs[id] {
+				continue
+			}
+		} else {
+			// events are sorted by time, so the deadlocks up to the previous time are reported
+			lastEventTime = event.EventTime
+			lastEventIDs = make(map[string]bool)
+		}
+		lastEventIDs[id] = true
+
+		var report deadlockReport
+		if err := xml.Unmarshal([]byte(event.DeadlockXML), &report); err != nil {
+			log.Error("Could not parse the deadlock report of %s: %s", event.EventTime.Format(time.RFC3339Nano), err.Error())
+		} else {
+			populateDeadlockSamples(instanceEntity, con.Host, obfuscator, id, event, &report)
+		}
+	}
+
+	if len(events) > 0 {
+		ids := make([]string, 0, len(lastEventIDs))
+		for id := range lastEventIDs {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		instanceState.SetWatermark(lastDeadlockKey, lastEventTime)
+		instanceState.SetEventIDs(lastDeadlockIDsKey, ids)
+	}
+}
+
+// deadlockID identifies a deadlock by its time and report
+func deadlockID(event deadlockEventModel) string {
+	sum := sha256.Sum256([]byte(event.EventTime.UTC().String() + event.DeadlockXML))
+	return hex.EncodeToString(sum[:8])
+}
+
+func populateDeadlockSamples(instanceEntity *integration.Entity, host string, obfuscator *obfuscation.Obfuscator, deadlockID string, event deadlockEventModel, report *deadlockReport) {
+	deadlockTime := event.EventTime.UTC().Format(time.RFC3339Nano)
+
+	newMetricSet := func(eventType string, attributes ...attribute.Attribute) *metric.Set {
+		return instanceEntity.NewMetricSet(eventType, append([]attribute.Attribute{
+			{Key: "displayName", Value: instanceEntity.Metadata.Name},
+			{Key: "entityName", Value: instanceEntity.Metadata.Namespace + ":" + instanceEntity.Metadata.Name},
+			{Key: "host", Value: host},
+			{Key: "instance", Value: instanceEntity.Metadata.Name},
+			{Key: "deadlockId", Value: deadlockID},
+			{Key: "deadlockTime", Value: deadlockTime},
+		}, attributes...)...)
+	}
+
+	victims := make(map[string]bool, len(report.Victims))
+	victimSessions := make([]string, 0, len(report.Victims))
+	for _, victim := range report.Victims {
+		victims[victim.ID] = true
+	}
+
+	for _, process := range report.Processes {
+		attributes := []attribute.Attribute{{Key: "processId", Value: process.ID}}
+		for _, a := range []struct{ key, value string }{
+			{"sessionId", process.SPID},
+			{"login", process.LoginName},
+			{"clientHost", process.HostName},
+			{"application", process.ClientApp},
+			{"database", process.DatabaseName},
+			{"waitResource", process.WaitResource},
+			{"lockMode", process.LockMode},
+			{"isolationLevel", process.IsolationLevel},
+			{"transactionName", process.TransactionName},
+			{"status", process.Status},
+		} {
+			if a.value != "" {
+				attributes = append(attributes, attribute.Attribute{Key: a.key, Value: a.value})
+			}
+		}
+		if text, ok := obfuscator.Apply(process.statement()); ok && text != "" {
+			attributes = append(attributes, attribute.Attribute{Key: "statement", Value: truncateQueryText(text)})
+		}
+
+		isVictim := 0
+		if victims[process.ID] {
+			isVictim = 1
+			victimSessions = append(victimSessions, process.SPID)
+		}
+
+		setDeadlockMetrics(newMetricSet("MssqlDeadlockProcessSample", attributes...), map[string]int64{
+			"process.isVictim":               int64(isVictim),
+			"process.waitTimeInMilliseconds": process.WaitTime,
+			"process.logUsedInBytes":         process.LogUsed,
+			"process.transactionCount":       process.TransactionCount,
+		})
+	}
+
+	resources := make([]string, 0, len(report.ResourceList.Resources))
+	for _, resource := range report.ResourceList.Resources {
+		resources = append(resources, resource.description())
+	}
+	setDeadlockMetrics(newMetricSet("MssqlDeadlockSample",
+		attribute.Attribute{Key: "victimSessionIds", Value: strings.Join(victimSessions, ",")},
+		attribute.Attribute{Key: "resources", Value: strings.Join(resources, "; ")},
+	), map[string]int64{
+		"deadlock.processes": int64(len(report.Processes)),
+		"deadlock.victims":   int64(len(report.Victims)),
+		"deadlock.resources": int64(len(report.ResourceList.Resources)),
+	})
+}
+
+func setDeadlockMetrics(metricSet *metric.Set, metrics map[string]int64) {
+	for name, value := range metrics {
+		if err := metricSet.SetMetric(name, value, metric.GAUGE); err != nil {
+			log.Error("Could not set deadlock metric '%s': %s", name, err.Error())
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/persist"
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/state"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+const testDeadlockXML = `<deadlock>
+ <victim-list><victimProcess id="process1f2a"/></victim-list>
+ <process-list>
+  <process id="process1f2a" taskpriority="0" logused="1200" waitresource="KEY: 5:72057594043760640 (8194443284a0)" waittime="4270" lockMode="U" trancount="2" isolationlevel="read committed (2)" spid="53" status="suspended" clientapp="billing" hostname="web01" loginname="app" currentdbname="sales" transactionname="user_transaction">
+   <executionStack><frame procname="adhoc" line="1">UPDATE accounts SET balance = 10 WHERE id = 7</frame></executionStack>
+   <inputbuf>UPDATE accounts SET balance = 10 WHERE id = 7</inputbuf>
+  </process>
+  <process id="process3b7c" logused="600" waitresource="KEY: 5:72057594043826176 (a1b2c3d4e5f6)" waittime="4100" lockMode="U" trancount="2" isolationlevel="read committed (2)" spid="61" status="suspended" clientapp="ssrs" hostname="bi01" loginname="report" currentdbname="sales" transactionname="user_transaction">
+   <executionStack><frame procname="adhoc" line="1"></frame></executionStack>
+   <inputbuf>UPDATE orders SET total = 5 WHERE id = 3</inputbuf>
+  </process>
+ </process-list>
+ <resource-list>
+  <keylock hobtid="72057594043760640" dbid="5" objectname="sales.dbo.accounts" indexname="PK_accounts" mode="X">
+   <owner-list><owner id="process3b7c" mode="X"/></owner-list>
+   <waiter-list><waiter id="process1f2a" mode="U" requestType="wait"/></waiter-list>
+  </keylock>
+  <keylock hobtid="72057594043826176" dbid="5" objectname="sales.dbo.orders" indexname="PK_orders" mode="X">
+   <owner-list><owner id="process1f2a" mode="X"/></owner-list>
+   <waiter-list><waiter id="process3b7c" mode="U" requestType="wait"/></waiter-list>
+  </keylock>
+ </resource-list>
+</deadlock>`
+
+func Test_populateDeadlockMetrics(t *testing.T) {
+	_, e := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	lastEventTime := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	eventTime := time.Date(2026, 10, 18, 9, 12, 30, 0, time.UTC)
+	store := persist.NewInMemoryStore()
+	store.Set(lastDeadlockKey, lastEventTime)
+
+	mock.ExpectQuery(`sys\.fn_xe_file_target_read_file.*timestamp_utc >= '2026-10-18T09:00:00\.0000000'.*WHERE event_time >= '2026-10-18T09:00:00\.0000000'`).
+		WillReturnRows(sqlmock.NewRows([]string{"event_time", "deadlock_xml"}).AddRow(eventTime, testDeadlockXML))
+
+	PopulateDeadlockMetrics(e, conn, args.ArgumentList{EnableDeadlockMetrics: true}, state.New(store, time.Time{}), nil)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var stored time.Time
+	_, err := store.Get(lastDeadlockKey, &stored)
+	assert.NoError(t, err)
+	assert.True(t, eventTime.Equal(stored))
+
+	assert.Len(t, e.Metrics, 3)
+	victim := e.Metrics[0].Metrics
+	assert.Equal(t, "MssqlDeadlockProcessSample", victim["event_type"])
+	assert.Equal(t, "53", victim["sessionId"])
+	assert.Equal(t, "app", victim["login"])
+	assert.Equal(t, "U", victim["lockMode"])
+	assert.Equal(t, "UPDATE accounts SET balance = ? WHERE id = ?", victim["statement"])
+	assert.Equal(t, float64(1), victim["process.isVictim"])
+	assert.Equal(t, float64(4270), victim["process.waitTimeInMilliseconds"])
+
+	// the input buffer is reported when the stack has no statement
+	survivor := e.Metrics[1].Metrics
+	assert.Equal(t, "UPDATE orders SET total = ? WHERE id = ?", survivor["statement"])
+	assert.Equal(t, float64(0), survivor["process.isVictim"])
+
+	deadlock := e.Metrics[2].Metrics
+	assert.Equal(t, "MssqlDeadlockSample", deadlock["event_type"])
+	assert.Equal(t, victim["deadlockId"], deadlock["deadlockId"])
+	assert.Equal(t, "2026-10-18T09:12:30Z", deadlock["deadlockTime"])
+	assert.Equal(t, "53", deadlock["victimSessionIds"])
+	assert.Equal(t, "keylock sales.dbo.accounts (PK_accounts); keylock sales.dbo.orders (PK_orders)", deadlock["resources"])
+	assert.Equal(t, float64(2), deadlock["deadlock.processes"])
+	assert.Equal(t, float64(1), deadlock["deadlock.victims"])
+	assert.Equal(t, float64(2), deadlock["deadlock.resources"])
+}
+
+func Test_populateDeadlockMetrics_SameTime(t *testing.T) {
+	_, e := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+	conn.ServerInfo = &connection.ServerInfo{MajorVersion: 13, EngineEdition: connection.EngineEditionEnterprise}
+
+	lastEventTime := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	reported := deadlockEventModel{EventTime: lastEventTime, DeadlockXML: testDeadlockXML}
+	store := persist.NewInMemoryStore()
+	store.Set(lastDeadlockKey, lastEventTime)
+	store.Set(lastDeadlockIDsKey, []string{deadlockID(reported)})
+
+	// the deadlock reported by the previous collection is read again with a new one at the same time
+	otherXML := strings.Replace(testDeadlockXML, `spid="53"`, `spid="54"`, 1)
+	mock.ExpectQuery(`sys\.fn_xe_file_target_read_file.*WHERE event_time >= '2026-10-18T09:00:00\.0000000'`).
+		WillReturnRows(sqlmock.NewRows([]string{"event_time", "deadlock_xml"}).
+			AddRow(lastEventTime, testDeadlockXML).
+			AddRow(lastEventTime, otherXML))
+
+	instanceState := state.New(store, time.Time{})
+	PopulateDeadlockMetrics(e, conn, args.ArgumentList{EnableDeadlockMetrics: true}, instanceState, nil)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Len(t, e.Metrics, 3)
+	assert.Equal(t, "54", e.Metrics[2].Metrics["victimSessionIds"])
+
+	watermark, _ := instanceState.Watermark(lastDeadlockKey)
+	assert.True(t, lastEventTime.Equal(watermark))
+	assert.ElementsMatch(t, []string{deadlockID(reported), deadlockID(deadlockEventModel{EventTime: lastEventTime, DeadlockXML: otherXML})},
+		instanceState.EventIDs(lastDeadlockIDsKey))
+}
+
+func Test_populateDeadlockMetrics_FirstCollectionRingBuffer(t *testing.T) {
+	_, e := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	// the first collection reports the deadlocks of the last hour
+	mock.ExpectQuery(`RingBufferTarget.*WHERE event_time >= DATEADD\(HOUR, -1, SYSUTCDATETIME\(\)\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"event_time", "deadlock_xml"}))
+
+	store := persist.NewInMemoryStore()
+	arguments := args.ArgumentList{EnableDeadlockMetrics: true, DeadlockSource: args.DeadlockSourceRingBuffer}
+	PopulateDeadlockMetrics(e, conn, arguments, state.New(store, time.Time{}), nil)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var stored time.Time
+	_, err := store.Get(lastDeadlockKey, &stored)
+	assert.Equal(t, persist.ErrNotFound, err)
+	assert.Empty(t, e.Metrics)
+}
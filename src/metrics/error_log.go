@@ -0,0 +1,188 @@
+package metrics
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/data/event"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/state"
+)
+
+const (
+	// lastErrorLogKey stores the time of the last error log entry read
+	lastErrorLogKey = "errorLog.lastEntryTime"
+	// lastErrorLogCountKey stores how many entries of that time were read, as entries written together share their time
+	lastErrorLogCountKey = "errorLog.lastEntryCount"
+	// errorLogCategory is the category of the error log events
+	errorLogCategory = "MssqlErrorLog"
+)
+
+// errorLogHeader matches the entry written before the message of an error, Ex: Error: 18456, Severity: 14, State: 8.
+var errorLogHeader = regexp.MustCompile(`^Error: (\d+), Severity: (\d+), State: (\d+)\.?$`)
+
+// errorLogEntry is a message of the error log, with its error if it has one
+type errorLogEntry struct {
+	logDate     time.Time
+	processInfo string
+	message     string
+	hasError    bool
+	errorNumber int
+	severity    int
+	state       int
+}
+
+// PopulateErrorLogEvents reports the entries written to the current error log since the previous collection as
+// MssqlErrorLog events of the instance entity. The time of the last entry read is kept in the state of the instance
+// so each entry is reported once. The first collection reports the entries of the last hour.
+func PopulateErrorLogEvents(instanceEntity *integration.Entity, con *connection.SQLConnection, arguments args.ArgumentList, instanceState *state.State, telemetry *Telemetry) {
+	if !arguments.EnableErrorLog {
+		telemetry.recordDisabled(errorLogDefinition)
+		return
+	}
+
+	if instanceState == nil {
+		telemetry.recordSkipped(errorLogDefinition)
+		return
+	}
+
+	if err := con.Err(); err != nil {
+		log.Warn("Skipping error log query: %s", err.Error())
+		telemetry.recordSkipped(errorLogDefinition)
+		return
+	}
+
+	queryDef, ok := errorLogDefinition.forServer(con.ServerInfo)
+	if !ok {
+		telemetry.recordUnsupported(errorLogDefinition)
+		return
+	}
+
+	var start *time.Time
+	var readAtStart int64
+	if lastEntryTime, ok := instanceState.Watermark(lastErrorLogKey); ok {
+		start = &lastEntryTime
+		readAtStart, _ = instanceState.IDWatermark(lastErrorLogCountKey)
+	}
+
+	rows := make([]errorLogModel, 0)
+	if err := runQueryDefinition(con, telemetry, queryDef, queryDef.GetQuery(errorLogReplace(start, errorLogLimit)), &rows); err != nil {
+		log.Error("Could not execute error log query: %s", err.Error())
+		return
+	}
+
+	rows = newErrorLogRows(rows, start, readAtStart, len(rows) == errorLogLimit)
+	if len(rows) == 0 {
+		return
+	}
+
+	// the entries read up to the last time, including the ones read before if it did not change
+	lastEntryTime := rows[len(rows)-1].LogDate
+	var readAtLast int64
+	if start != nil && lastEntryTime.Equal(*start) {
+		readAtLast = readAtStart
+	}
+	for _, row := range rows {
+		if row.LogDate.Equal(lastEntryTime) {
+			readAtLast++
+		}
+	}
+	instanceState.SetWatermark(lastErrorLogKey, lastEntryTime)
+	instanceState.SetIDWatermark(lastErrorLogCountKey, readAtLast)
+
+	include, _ := regexp.Compile(arguments.ErrorLogInclude)
+	exclude, _ := regexp.Compile(arguments.ErrorLogExclude)
+	for _, entry := range parseErrorLog(rows) {
+		if entry.severity < arguments.ErrorLogMinSeverity || !include.MatchString(entry.message) ||
+			(arguments.ErrorLogExclude != "" && exclude.MatchString(entry.message)) {
+			continue
+		}
+
+		if err := instanceEntity.AddEvent(errorLogEvent(instanceEntity, con.Host, entry)); err != nil {
+			log.Error("Could not add error log event: %s", err.Error())
+		}
+	}
+}
+
+// newErrorLogRows leaves out the rows read by the previous collection: the ones before start and the first
+// readAtStart ones at start. If the rows were limited, an error header is left for the next collection along
+// with its message.
+func newErrorLogRows(rows []errorLogModel, start *time.Time, readAtStart int64, limited bool) []errorLogModel {
+	newRows := make([]errorLogModel, 0, len(rows))
+	for _, row := range rows {
+		if start != nil {
+			if row.LogDate.Before(*start) {
+				continue
+			}
+			if row.LogDate.Equal(*start) && readAtStart > 0 {
+				readAtStart--
+				continue
+			}
+		}
+		newRows = append(newRows, row)
+	}
+
+	if last := len(newRows) - 1; limited && last >= 0 && newRows[last].Text != nil && errorLogHeader.MatchString(strings.TrimSpace(*newRows[last].Text)) {
+		newRows = newRows[:last]
+	}
+	return newRows
+}
+
+// parseErrorLog returns the entries of the error log rows. An error is logged as a header with its number,
+// severity and state followed by its message, both with the same time and process.
+func parseErrorLog(rows []errorLogModel) []errorLogEntry {
+	entries := make([]errorLogEntry, 0, len(rows))
+	for index := 0; index < len(rows); index++ {
+		row := rows[index]
+		entry := errorLogEntry{logDate: row.LogDate}
+		if row.ProcessInfo != nil {
+			entry.processInfo = *row.ProcessInfo
+		}
+		if row.Text != nil {
+			entry.message = strings.TrimSpace(*row.Text)
+		}
+
+		if match := errorLogHeader.FindStringSubmatch(entry.message); match != nil {
+			entry.hasError = true
+			entry.errorNumber, _ = strconv.Atoi(match[1])
+			entry.severity, _ = strconv.Atoi(match[2])
+			entry.state, _ = strconv.Atoi(match[3])
+
+			if next := index + 1; next < len(rows) && rows[next].LogDate.Equal(row.LogDate) &&
+				rows[next].Text != nil && !errorLogHeader.MatchString(strings.TrimSpace(*rows[next].Text)) &&
+				sameProcess(row.ProcessInfo, rows[next].ProcessInfo) {
+				entry.message = strings.TrimSpace(*rows[next].Text)
+				index = next
+			}
+		}
+
+		if entry.message != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func sameProcess(a, b *string) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func errorLogEvent(instanceEntity *integration.Entity, host string, entry errorLogEntry) *event.Event {
+	attributes := map[string]interface{}{
+		"host":        host,
+		"instance":    instanceEntity.Metadata.Name,
+		"logTime":     entry.logDate.Format("2006-01-02T15:04:05.000"),
+		"processInfo": entry.processInfo,
+	}
+	if entry.hasError {
+		attributes["errorNumber"] = entry.errorNumber
+		attributes["severity"] = entry.severity
+		attributes["state"] = entry.state
+	}
+	return event.NewWithAttributes(truncateQueryText(entry.message), errorLogCategory, attributes)
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Placeholders of the error log query
+const (
+	errorLogStartPlaceHolder    = "%START%"
+	errorLogPreviousPlaceHolder = "%READ_PREVIOUS%"
+	errorLogLimitPlaceHolder    = "%LIMIT%"
+)
+
+// errorLogFirstStart are the entries read by the first collection, the ones of the last hour. The error log is in
+// the local time of the server.
+const errorLogFirstStart = "DATEADD(HOUR, -1, GETDATE())"
+
+// errorLogLimit is the maximum number of entries read in a collection, the rest are read by the next ones
+const errorLogLimit = 1000
+
+// errorLogModel is an entry of the error log
+type errorLogModel struct {
+	LogDate     time.Time `db:"log_date"`
+	ProcessInfo *string   `db:"process_info"`
+	Text        *string   `db:"text"`
+}
+
+// errorLogDefinition reads the entries of the current error log since START, in the order they were written.
+// When READ_PREVIOUS is set and the oldest entry of the current log is newer than START, the log was cycled
+// since the previous collection, so the entries of the previous log since START are read first.
+var errorLogDefinition = &QueryDefinition{
+	name: "error_log",
+	query: `SET NOCOUNT ON;
+	DECLARE @start datetime = %START%;
+	DECLARE @read_previous bit = %READ_PREVIOUS%;
+	CREATE TABLE #error_log (row_id int IDENTITY(1, 1), log_date datetime, process_info nvarchar(100), text nvarchar(max));
+	CREATE TABLE #previous_error_log (row_id int IDENTITY(1, 1), log_date datetime, process_info nvarchar(100), text nvarchar(max));
+	INSERT INTO #error_log (log_date, process_info, text) EXEC master.dbo.xp_readerrorlog 0, 1, NULL, NULL, @start, NULL, N'asc';
+	IF @read_previous = 1 AND NOT EXISTS (SELECT 1 FROM #error_log WHERE log_date <= @start)
+		INSERT INTO #previous_error_log (log_date, process_info, text) EXEC master.dbo.xp_readerrorlog 1, 1, NULL, NULL, @start, NULL, N'asc';
+	SELECT TOP (%LIMIT%) log_date, process_info, text FROM (
+		SELECT 0 AS log_order, row_id, log_date, process_info, text FROM #previous_error_log
+		UNION ALL
+		SELECT 1 AS log_order, row_id, log_date, process_info, text FROM #error_log
+	) AS entries ORDER BY log_order, row_id;
+	DROP TABLE #previous_error_log;
+	DROP TABLE #error_log;`,
+	dataModels: &[]errorLogModel{},
+	editions:   serverEditions,
+}
+
+// errorLogReplace sets the time the entries are read from and the maximum entries read. The previous log is
+// only read from the last entry read, not in the first collection.
+func errorLogReplace(start *time.Time, limit int) QueryModifier {
+	return func(query string) string {
+		value, readPrevious := errorLogFirstStart, "0"
+		if start != nil {
+			value, readPrevious = "'"+start.UTC().Format("2006-01-02T15:04:05.000")+"'", "1"
+		}
+		query = strings.Replace(query, errorLogStartPlaceHolder, value, -1)
+		query = strings.Replace(query, errorLogPreviousPlaceHolder, readPrevious, -1)
+		return strings.Replace(query, errorLogLimitPlaceHolder, strconv.Itoa(limit), -1)
+	}
+}
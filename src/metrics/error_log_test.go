@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/persist"
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/state"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func Test_populateErrorLogEvents(t *testing.T) {
+	_, e := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	first := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	second := first.Add(250 * time.Millisecond)
+	store := persist.NewInMemoryStore()
+	instanceState := state.New(store, time.Time{})
+	instanceState.SetWatermark(lastErrorLogKey, first)
+	instanceState.SetIDWatermark(lastErrorLogCountKey, 1)
+
+	mock.ExpectQuery(`xp_readerrorlog 0, 1, NULL, NULL, @start.*SELECT TOP \(1000\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"log_date", "process_info", "text"}).
+			// read by the previous collection
+			AddRow(first, "spid12s", "Recovery is complete.").
+			AddRow(first, "Logon", "Login succeeded for user 'app'.").
+			AddRow(second, "Logon", "Error: 18456, Severity: 14, State: 8.").
+			AddRow(second, "Logon", "Login failed for user 'sa'. Reason: Password did not match that for the login provided. [CLIENT: 10.0.0.7]").
+			AddRow(second, "spid55", "Error: 1205, Severity: 13, State: 51.").
+			AddRow(second, "spid55", "Transaction (Process ID 55) was deadlocked on lock resources with another process and has been chosen as the deadlock victim."))
+
+	arguments := args.ArgumentList{EnableErrorLog: true, ErrorLogExclude: "^Login succeeded"}
+	PopulateErrorLogEvents(e, conn, arguments, instanceState, nil)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Len(t, e.Events, 2)
+	loginFailed := e.Events[0]
+	assert.Equal(t, errorLogCategory, loginFailed.Category)
+	assert.Equal(t, "Login failed for user 'sa'. Reason: Password did not match that for the login provided. [CLIENT: 10.0.0.7]", loginFailed.Summary)
+	assert.Equal(t, 18456, loginFailed.Attributes["errorNumber"])
+	assert.Equal(t, 14, loginFailed.Attributes["severity"])
+	assert.Equal(t, 8, loginFailed.Attributes["state"])
+	assert.Equal(t, "Logon", loginFailed.Attributes["processInfo"])
+	assert.Equal(t, "2026-10-18T09:00:00.250", loginFailed.Attributes["logTime"])
+	assert.Equal(t, 1205, e.Events[1].Attributes["errorNumber"])
+
+	watermark, _ := instanceState.Watermark(lastErrorLogKey)
+	assert.True(t, second.Equal(watermark))
+	read, _ := instanceState.IDWatermark(lastErrorLogCountKey)
+	assert.Equal(t, int64(4), read)
+}
+
+func Test_populateErrorLogEvents_FirstCollection(t *testing.T) {
+	_, e := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	logDate := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`DECLARE @start datetime = DATEADD\(HOUR, -1, GETDATE\(\)\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"log_date", "process_info", "text"}).
+			AddRow(logDate, "spid8s", "SQL Server is now ready for client connections. This is an informational message; no user action is required.").
+			AddRow(logDate, "spid8s", "Error: 17806, Severity: 20, State: 14."))
+
+	instanceState := state.New(persist.NewInMemoryStore(), time.Time{})
+	PopulateErrorLogEvents(e, conn, args.ArgumentList{EnableErrorLog: true, ErrorLogMinSeverity: 16}, instanceState, nil)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// the informational message is below the minimum severity and the header is reported without its message
+	assert.Len(t, e.Events, 1)
+	assert.Equal(t, "Error: 17806, Severity: 20, State: 14.", e.Events[0].Summary)
+	assert.Equal(t, 20, e.Events[0].Attributes["severity"])
+
+	read, _ := instanceState.IDWatermark(lastErrorLogCountKey)
+	assert.Equal(t, int64(2), read)
+}
+
+func Test_newErrorLogRows_Limited(t *testing.T) {
+	logDate := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	header := "Error: 823, Severity: 24, State: 2."
+	message := "The operating system returned error 21 to SQL Server during a read."
+	rows := []errorLogModel{
+		{LogDate: logDate, Text: &message},
+		{LogDate: logDate, Text: &header},
+	}
+
+	// the header is read again with its message by the next collection
+	assert.Len(t, newErrorLogRows(rows, nil, 0, true), 1)
+	assert.Len(t, newErrorLogRows(rows, nil, 0, false), 2)
+}
+
+func Test_populateErrorLogEvents_LogCycled(t *testing.T) {
+	_, e := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	watermark := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	cycled := watermark.Add(time.Minute)
+	instanceState := state.New(persist.NewInMemoryStore(), time.Time{})
+	instanceState.SetWatermark(lastErrorLogKey, watermark)
+	instanceState.SetIDWatermark(lastErrorLogCountKey, 1)
+
+	// the current log starts after the last entry read, so the previous log is read from it before the current one
+	mock.ExpectQuery(`DECLARE @start datetime = '2026-10-18T09:00:00\.000';\s*DECLARE @read_previous bit = 1;.*` +
+		`IF @read_previous = 1 AND NOT EXISTS \(SELECT 1 FROM #error_log WHERE log_date <= @start\)\s*` +
+		`INSERT INTO #previous_error_log .* EXEC master\.dbo\.xp_readerrorlog 1, 1, NULL, NULL, @start, NULL, N'asc';.*` +
+		`ORDER BY log_order, row_id`).
+		WillReturnRows(sqlmock.NewRows([]string{"log_date", "process_info", "text"}).
+			// previous log, the first entry was read by the previous collection
+			AddRow(watermark, "spid20s", "Error: 9002, Severity: 17, State: 2.").
+			AddRow(watermark.Add(time.Second), "spid52", "Error: 1205, Severity: 13, State: 51.").
+			AddRow(watermark.Add(time.Second), "spid52", "Transaction (Process ID 52) was deadlocked on lock resources with another process and has been chosen as the deadlock victim.").
+			// current log
+			AddRow(cycled, "spid61", "Attempting to cycle error log. This is an informational message only; no user action is required.").
+			AddRow(cycled, "Logon", "Error: 18456, Severity: 14, State: 5.").
+			AddRow(cycled, "Logon", "Login failed for user 'app'. Reason: Could not find a login matching the name provided. [CLIENT: 10.0.0.9]"))
+
+	PopulateErrorLogEvents(e, conn, args.ArgumentList{EnableErrorLog: true, ErrorLogMinSeverity: 13}, instanceState, nil)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Len(t, e.Events, 2)
+	assert.Equal(t, 1205, e.Events[0].Attributes["errorNumber"])
+	assert.Equal(t, 18456, e.Events[1].Attributes["errorNumber"])
+
+	last, _ := instanceState.Watermark(lastErrorLogKey)
+	assert.True(t, cycled.Equal(last))
+	read, _ := instanceState.IDWatermark(lastErrorLogCountKey)
+	assert.Equal(t, int64(3), read)
+}
+
+func Test_errorLogReplace(t *testing.T) {
+	start := time.Date(2026, 10, 18, 9, 0, 0, 250000000, time.UTC)
+	query := errorLogDefinition.GetQuery(errorLogReplace(&start, 10))
+	assert.Contains(t, query, "DECLARE @start datetime = '2026-10-18T09:00:00.250';")
+	assert.Contains(t, query, "DECLARE @read_previous bit = 1;")
+	assert.Contains(t, query, "SELECT TOP (10)")
+
+	// the first collection only reads the current log
+	query = errorLogDefinition.GetQuery(errorLogReplace(nil, 10))
+	assert.Contains(t, query, "DECLARE @start datetime = "+errorLogFirstStart+";")
+	assert.Contains(t, query, "DECLARE @read_previous bit = 0;")
+}
@@ -2,6 +2,7 @@ package metrics
 
 var instanceDefinitions = []*QueryDefinition{
 	{
+		name: "instance_performance_counters",
 		query: `SELECT
 		t1.cntr_value AS sql_compilations,
 		t2.cntr_value AS sql_recompilations,
@@ -47,6 +48,7 @@ var instanceDefinitions = []*QueryDefinition{
 		}{},
 	},
 	{
+		name: "instance_buffer_pool_hit_percent",
 		query: `SELECT (a.cntr_value * 1.0 / b.cntr_value) * 100.0 AS buffer_pool_hit_percent
 		FROM sys.dm_os_performance_counters 
 		a JOIN (SELECT cntr_value, OBJECT_NAME FROM sys.dm_os_performance_counters WHERE counter_name = 'Buffer cache hit ratio base') 
@@ -57,6 +59,7 @@ var instanceDefinitions = []*QueryDefinition{
 		}{},
 	},
 	{
+		name: "instance_wait_time",
 		query: `SELECT
 		Sum(wait_time_ms) AS wait_time
 		FROM sys.dm_os_wait_stats
@@ -79,6 +82,7 @@ var instanceDefinitions = []*QueryDefinition{
 		}{},
 	},
 	{
+		name: "instance_processes",
 		query: `SELECT
 		Max(CASE WHEN sessions.status = 'preconnect' THEN counts ELSE 0 END) AS preconnect,
 		Max(CASE WHEN sessions.status = 'background' THEN counts ELSE 0 END) AS background,
@@ -109,6 +113,7 @@ var instanceDefinitions = []*QueryDefinition{
 		}{},
 	},
 	{
+		name: "instance_runnable_tasks",
 		query: `SELECT Sum(runnable_tasks_count) AS runnable_tasks_count
 		FROM sys.dm_os_schedulers
 		WHERE   scheduler_id < 255 AND [status] = 'VISIBLE ONLINE'`,
@@ -117,12 +122,24 @@ var instanceDefinitions = []*QueryDefinition{
 		}{},
 	},
 	{
+		name:  "instance_active_connections",
 		query: `SELECT Count(dbid) AS instance_active_connections FROM sys.sysprocesses WITH (nolock) WHERE dbid > 0`,
 		dataModels: &[]struct {
 			InstanceActiveConnections *int64 `db:"instance_active_connections" metric_name:"activeConnections" source_type:"gauge"`
 		}{},
+		editions: serverEditions,
+		variants: []*QueryDefinition{
+			{
+				// sys.sysprocesses is not available in Azure SQL Database
+				query: `SELECT Count(*) AS instance_active_connections FROM sys.dm_exec_sessions WITH (nolock) WHERE database_id > 0`,
+				dataModels: &[]struct {
+					InstanceActiveConnections *int64 `db:"instance_active_connections" metric_name:"activeConnections" source_type:"gauge"`
+				}{},
+			},
+		},
 	},
 	{
+		name: "instance_memory",
 		query: `SELECT
 		Max(sys_mem.total_physical_memory_kb * 1024.0) AS total_physical_memory,
 		Max(sys_mem.available_physical_memory_kb * 1024.0) AS available_physical_memory,
@@ -135,11 +152,13 @@ var instanceDefinitions = []*QueryDefinition{
 			AvailablePhysicalMemory *float64 `db:"available_physical_memory" metric_name:"memoryAvailable" source_type:"gauge"`
 			MemoryUtilization       *float64 `db:"memory_utilization" metric_name:"memoryUtilization" source_type:"gauge"`
 		}{},
+		editions: serverEditions,
 	},
 }
 
 var instanceBufferDefinitions = []*QueryDefinition{
 	{
+		name: "instance_buffer_pool_size",
 		query: ` SELECT
       Count_big(*) * (8*1024) AS instance_buffer_pool_size
       FROM sys.dm_os_buffer_descriptors WITH (nolock)
@@ -150,18 +169,9 @@ var instanceBufferDefinitions = []*QueryDefinition{
 	},
 }
 
-var waitTimeQuery = `SELECT wait_type, wait_time_ms AS wait_time, waiting_tasks_count
-FROM sys.dm_os_wait_stats wait_stats
-WHERE wait_time_ms != 0`
-
-type waitTimeModel struct {
-	WaitType  *string `db:"wait_type"`
-	WaitTime  *int64  `db:"wait_time"`
-	WaitCount *int64  `db:"waiting_tasks_count"`
-}
-
 var diskMetricInBytesDefination = []*QueryDefinition{
 	{
+		name: "instance_disk_space",
 		query: `SELECT Sum(total_bytes) AS total_disk_space FROM (
 			SELECT DISTINCT
 			dovs.volume_mount_point,
@@ -173,5 +183,6 @@ var diskMetricInBytesDefination = []*QueryDefinition{
 		dataModels: &[]struct {
 			TotalDiskSpace *int64 `db:"total_disk_space" metric_name:"instance.diskInBytes" source_type:"gauge"`
 		}{},
+		editions: serverEditions,
 	},
 }
@@ -2,19 +2,52 @@ package metrics
 
 import (
 	"reflect"
+
+	"github.com/newrelic/nri-mssql/src/connection"
 )
 
+// serverEditions are the engine editions running a full SQL Server instance, exposing the server scoped views
+// (sys.dm_os_*, sys.sysprocesses...) and allowing to switch databases with USE. Azure SQL Database only
+// exposes the views of the database the connection is established to.
+var serverEditions = []int{
+	connection.EngineEditionPersonal,
+	connection.EngineEditionStandard,
+	connection.EngineEditionEnterprise,
+	connection.EngineEditionExpress,
+	connection.EngineEditionAzureSQLManagedInstance,
+	connection.EngineEditionAzureSQLEdge,
+}
+
+// azureSQLDatabaseEditions are the engine editions of the queries specific to Azure SQL Database
+var azureSQLDatabaseEditions = []int{connection.EngineEditionAzureSQLDatabase}
+
 // QueryDefinition defines a single query with it's associated
 // data model which has struct tags for metric.Set
 type QueryDefinition struct {
+	name       string
 	query      string
 	dataModels interface{}
+
+	// minVersion and maxVersion limit the major versions supporting the query, 0 means no limit
+	minVersion int
+	maxVersion int
+	// editions supporting the query, all of them if empty
+	editions []int
+	// platforms supporting the query, all of them if empty
+	platforms []string
+	// variants are alternatives to the query, used in order when the server does not support it
+	variants []*QueryDefinition
 }
 
 // QueryModifier is a function that takes in a query, does any modification
 // and returns the query
 type QueryModifier func(string) string
 
+// GetName retrieves the name identifying a QueryDefinition in the integration telemetry
+func (qd QueryDefinition) GetName() string {
+	return qd.name
+}
+
 // GetQuery retrieves the query for a QueryDefinition
 func (qd QueryDefinition) GetQuery(modifiers ...QueryModifier) string {
 	modifiedQuery := qd.query
@@ -33,3 +66,66 @@ func (qd QueryDefinition) GetDataModels() interface{} {
 	ptr := reflect.New(reflect.ValueOf(qd.dataModels).Elem().Type())
 	return ptr.Interface()
 }
+
+// supports returns true if the server described by info can run the query.
+// Every query is supported if the server is unknown.
+func (qd QueryDefinition) supports(info *connection.ServerInfo) bool {
+	if info == nil {
+		return true
+	}
+
+	if qd.minVersion > 0 && info.MajorVersion < qd.minVersion {
+		return false
+	}
+	if qd.maxVersion > 0 && info.MajorVersion > qd.maxVersion {
+		return false
+	}
+
+	return supportsEdition(qd.editions, info.EngineEdition) && supportsPlatform(qd.platforms, info.Platform)
+}
+
+// supportsEdition returns true if edition is in editions, or editions is empty
+func supportsEdition(editions []int, edition int) bool {
+	if len(editions) == 0 {
+		return true
+	}
+	for _, supported := range editions {
+		if supported == edition {
+			return true
+		}
+	}
+
+	return false
+}
+
+// supportsPlatform returns true if platform is in platforms, or platforms is empty
+func supportsPlatform(platforms []string, platform string) bool {
+	if len(platforms) == 0 {
+		return true
+	}
+	for _, supported := range platforms {
+		if supported == platform {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forServer returns the definition, or the first of its variants, supported by the server described by info.
+// Variants keep the name of the definition. It returns false if none of them is supported.
+func (qd *QueryDefinition) forServer(info *connection.ServerInfo) (*QueryDefinition, bool) {
+	if qd.supports(info) {
+		return qd, true
+	}
+
+	for _, variant := range qd.variants {
+		if variant.supports(info) {
+			resolved := *variant
+			resolved.name = qd.name
+			return &resolved, true
+		}
+	}
+
+	return nil, false
+}
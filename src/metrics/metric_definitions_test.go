@@ -4,6 +4,9 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/stretchr/testify/assert"
 )
 
 func Test_QueryDefinition_GetQuery(t *testing.T) {
@@ -52,3 +55,47 @@ func Test_QueryDefinition_GetDataModels(t *testing.T) {
 		t.Errorf("Expected %+v to not equal %+v", out, control)
 	}
 }
+
+func Test_QueryDefinition_forServer(t *testing.T) {
+	azureVariant := &QueryDefinition{query: "select azure"}
+	def := &QueryDefinition{
+		name:       "test_query",
+		query:      "select box",
+		minVersion: 13,
+		maxVersion: 15,
+		editions:   []int{connection.EngineEditionStandard, connection.EngineEditionEnterprise},
+		variants:   []*QueryDefinition{azureVariant},
+	}
+	noVariants := &QueryDefinition{name: "no_variants", query: "select box", minVersion: 13}
+	windowsOnly := &QueryDefinition{name: "windows_only", query: "select windows", platforms: []string{connection.PlatformWindows}}
+
+	testCases := []struct {
+		name          string
+		info          *connection.ServerInfo
+		def           *QueryDefinition
+		expectedQuery string
+		supported     bool
+	}{
+		{"Unknown Server", nil, def, "select box", true},
+		{"Supported", &connection.ServerInfo{MajorVersion: 14, EngineEdition: connection.EngineEditionStandard}, def, "select box", true},
+		{"Variant Edition", &connection.ServerInfo{MajorVersion: 12, EngineEdition: connection.EngineEditionAzureSQLDatabase}, def, "select azure", true},
+		{"Variant Version", &connection.ServerInfo{MajorVersion: 16, EngineEdition: connection.EngineEditionEnterprise}, def, "select azure", true},
+		{"Older Version", &connection.ServerInfo{MajorVersion: 11, EngineEdition: connection.EngineEditionEnterprise}, noVariants, "", false},
+		{"Supported Platform", &connection.ServerInfo{MajorVersion: 15, EngineEdition: connection.EngineEditionStandard, Platform: connection.PlatformWindows}, windowsOnly, "select windows", true},
+		{"Unsupported Platform", &connection.ServerInfo{MajorVersion: 15, EngineEdition: connection.EngineEditionStandard, Platform: connection.PlatformLinux}, windowsOnly, "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resolved, ok := tc.def.forServer(tc.info)
+			assert.Equal(t, tc.supported, ok)
+			if ok {
+				assert.Equal(t, tc.expectedQuery, resolved.GetQuery())
+				assert.Equal(t, tc.def.GetName(), resolved.GetName())
+			}
+		})
+	}
+
+	// variants keep the name of the definition without modifying the variant
+	assert.Empty(t, azureVariant.GetName())
+}
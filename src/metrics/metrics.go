@@ -2,12 +2,19 @@
 package metrics
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
 	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
@@ -16,15 +23,343 @@ import (
 	"github.com/newrelic/nri-mssql/src/args"
 	"github.com/newrelic/nri-mssql/src/connection"
 	"github.com/newrelic/nri-mssql/src/database"
+	"github.com/newrelic/nri-mssql/src/obfuscation"
+	"github.com/newrelic/nri-mssql/src/state"
 	"gopkg.in/yaml.v2"
 )
 
 type customQuery struct {
-	Query    string
-	Prefix   string
-	Name     string `yaml:"metric_name"`
-	Type     string `yaml:"metric_type"`
-	Database string
+	Query  string
+	Prefix string
+	Name   string `yaml:"metric_name"`
+	Type   string `yaml:"metric_type"`
+	// Databases are the databases the query runs in: a name, a list of names or "*" for every monitored database.
+	// DatabaseInclude and DatabaseExclude filter them with patterns like the ones of database_include.
+	Databases       databaseNames `yaml:"database"`
+	DatabaseInclude string        `yaml:"database_include"`
+	DatabaseExclude string        `yaml:"database_exclude"`
+	// Database is the database a run of the query uses
+	Database string `yaml:"-"`
+	// QueryTextColumns are the columns with SQL text besides the defaultQueryTextColumns
+	QueryTextColumns []string `yaml:"query_text_columns"`
+	// Interval is how often the query runs, on every collection if not set. Offset shifts the time
+	// it runs within the interval, Ex: interval 1h and offset 15m run the query at a quarter past every hour.
+	Interval time.Duration
+	Offset   time.Duration
+	// Entity is the entity the rows are reported on: instance (default) or database
+	Entity string
+	// EventType is the event type of the samples of the rows reported on the instance, MssqlCustomQuerySample by default
+	EventType string `yaml:"event_type"`
+	// Attributes are static attributes added to every row, Ex: team, service or environment
+	Attributes map[string]string
+	// Columns declare how some columns are reported, by column name. The type of the rest is detected from their values.
+	Columns map[string]customQueryColumn
+
+	// obfuscator handles the SQL text columns according to the query text mode
+	obfuscator *obfuscation.Obfuscator
+	// dbSetLookup has the MssqlDatabaseSample of each database the rows of queries on database entities are set on
+	dbSetLookup database.DBMetricSetLookup
+	// telemetryName is the name the runs of the query are recorded with in the telemetry
+	telemetryName string
+}
+
+// Names the custom queries are recorded with in the telemetry
+const (
+	customQueryTelemetryPrefix   = "custom_query:"
+	customMetricsQueryTelemetry  = customQueryTelemetryPrefix + "custom_metrics_query"
+	customMetricsConfigTelemetry = customQueryTelemetryPrefix + "custom_metrics_config"
+)
+
+// definition returns the definition the query is recorded with in the telemetry
+func (cq customQuery) definition() *QueryDefinition {
+	return &QueryDefinition{name: cq.telemetryName, query: cq.Query}
+}
+
+// customQueryColumn declares how a column of a custom query is reported instead of detecting its type from the value
+type customQueryColumn struct {
+	// Type is gauge, rate, delta, cumulative_counter, attribute or ignore, detected from the value if not set
+	Type string
+	// Name is the name the column is reported with after the prefix of the query, the column name by default
+	Name string
+	// Unit is added to the name of metrics, Ex: unit bytes reports column size as sizeInBytes
+	Unit string
+}
+
+// columnTypeIgnore leaves a column out of the samples
+const columnTypeIgnore = "ignore"
+
+// columnSourceTypes are the source types of the types of the columns reported
+var columnSourceTypes = map[string]metric.SourceType{
+	"gauge": metric.GAUGE,
+	"rate":  metric.RATE,
+	"delta": metric.DELTA,
+	// counters increasing since the server started are reported as their increase, leaving out their resets
+	"cumulative_counter": metric.PDELTA,
+	"attribute":          metric.ATTRIBUTE,
+}
+
+// customQueryTargetColumns are the columns defining the metric of metric_name, which cannot be declared
+var customQueryTargetColumns = []string{"metric_name", "metric_type", "metric_value"}
+
+// validate returns an error if the declaration of the column is not valid
+func (c customQueryColumn) validate(columnName string) error {
+	for _, target := range customQueryTargetColumns {
+		if strings.EqualFold(columnName, target) {
+			return fmt.Errorf("column %s defines the metric of metric_name and cannot be declared", columnName)
+		}
+	}
+
+	sourceType, ok := columnSourceTypes[c.Type]
+	switch {
+	case c.Type != "" && !ok && c.Type != columnTypeIgnore:
+		return fmt.Errorf("column %s has type %q, it must be gauge, rate, delta, cumulative_counter, attribute or ignore", columnName, c.Type)
+	case c.Unit != "" && (c.Type == columnTypeIgnore || sourceType == metric.ATTRIBUTE):
+		return fmt.Errorf("column %s of type %s cannot have a unit", columnName, c.Type)
+	}
+	return nil
+}
+
+// outputName returns the name the column is reported with, without the prefix of the query
+func (c customQueryColumn) outputName(columnName string) string {
+	name := columnName
+	if c.Name != "" {
+		name = c.Name
+	}
+	if c.Unit != "" {
+		name += "In" + strings.ToUpper(c.Unit[:1]) + c.Unit[1:]
+	}
+	return name
+}
+
+// column returns the declaration of the column of the query, if any
+func (cq customQuery) column(columnName string) (customQueryColumn, bool) {
+	if column, ok := cq.Columns[columnName]; ok {
+		return column, true
+	}
+	for name, column := range cq.Columns {
+		if strings.EqualFold(name, columnName) {
+			return column, true
+		}
+	}
+	return customQueryColumn{}, false
+}
+
+// allDatabases selects every monitored database as the databases of a custom query
+const allDatabases = "*"
+
+// databaseNames are the databases of a custom query, written in YAML either as a single name or as a list
+type databaseNames []string
+
+// UnmarshalYAML accepts a single name as well as a list of names
+func (d *databaseNames) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		*d = nil
+		if name != "" {
+			*d = databaseNames{name}
+		}
+		return nil
+	}
+
+	var names []string
+	if err := unmarshal(&names); err != nil {
+		return err
+	}
+	*d = names
+	return nil
+}
+
+// fansOut returns true if the query runs in several databases instead of a single one
+func (cq customQuery) fansOut() bool {
+	if len(cq.Databases) > 1 || cq.DatabaseInclude != "" || cq.DatabaseExclude != "" {
+		return true
+	}
+	return len(cq.Databases) == 1 && cq.Databases[0] == allDatabases
+}
+
+// databases returns the databases a query fanning out runs in, sorted: the ones listed, along with the monitored
+// ones when it lists "*" or none, that match its include and exclude patterns
+func (cq customQuery) databases(monitored []string) []string {
+	candidates := make(map[string]bool)
+	withMonitored := len(cq.Databases) == 0
+	for _, name := range cq.Databases {
+		if name == allDatabases {
+			withMonitored = true
+			continue
+		}
+		candidates[name] = true
+	}
+	if withMonitored {
+		for _, name := range monitored {
+			candidates[name] = true
+		}
+	}
+
+	// the patterns are checked when parsing the queries
+	filter, _ := database.NewNameFilter(cq.DatabaseInclude, cq.DatabaseExclude, true)
+	dbNames := make([]string, 0, len(candidates))
+	for dbName := range candidates {
+		if filter.Match(dbName) {
+			dbNames = append(dbNames, dbName)
+		}
+	}
+	sort.Strings(dbNames)
+	return dbNames
+}
+
+// defaultQueryTextColumns are the column names of custom queries taken as SQL text. Generic names such as
+// text or statement are often not SQL, so they have to be listed in the query_text_columns of the query.
+var defaultQueryTextColumns = []string{"query_text", "sql_text", "statement_text"}
+
+// isQueryTextColumn returns true if the column of the custom query has SQL text
+func (cq customQuery) isQueryTextColumn(columnName string) bool {
+	for _, names := range [][]string{defaultQueryTextColumns, cq.QueryTextColumns} {
+		for _, name := range names {
+			if strings.EqualFold(name, columnName) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Entities the rows of a custom query are reported on
+const (
+	customQueryEntityInstance = "instance"
+	customQueryEntityDatabase = "database"
+	// dbNameColumn is the column with the database of each row of queries reported on database entities
+	dbNameColumn = "db_name"
+)
+
+// defaultCustomQueryEventType is the event type of the samples of custom queries not declaring one
+const defaultCustomQueryEventType = "MssqlCustomQuerySample"
+
+// eventTypeRegex matches the valid event types of custom queries
+var eventTypeRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_:]{0,254}$`)
+
+// reservedEventTypeRegex matches the event types of the samples of the integration, which custom queries cannot
+// report other than the default one, so new samples of the integration do not clash with them
+var reservedEventTypeRegex = regexp.MustCompile(`(?i)^Mssql.*Sample$`)
+
+// reservedAttributes are the attributes of the samples of custom queries that static attributes cannot replace
+var reservedAttributes = []string{"event_type", "displayName", "entityName", "host", "instance", "database"}
+
+// eventType returns the event type of the samples of the query
+func (cq customQuery) eventType() string {
+	if cq.EventType == "" {
+		return defaultCustomQueryEventType
+	}
+	return cq.EventType
+}
+
+// staticAttributes returns the static attributes of the query sorted by name
+func (cq customQuery) staticAttributes() []attribute.Attribute {
+	attributes := make([]attribute.Attribute, 0, len(cq.Attributes))
+	for key, value := range cq.Attributes {
+		attributes = append(attributes, attribute.Attribute{Key: key, Value: value})
+	}
+	sort.Slice(attributes, func(i, j int) bool { return attributes[i].Key < attributes[j].Key })
+	return attributes
+}
+
+// rowAttributes returns the columns of a row declared as attributes, with the names and values they are reported
+// with, sorted by name. They tell apart the rows of the query, so the rate, delta and cumulative_counter columns of
+// a row are computed from the previous value of the same row.
+func (cq customQuery) rowAttributes(columns []string, dbMetrics map[string]customQueryMetricValue) []attribute.Attribute {
+	attributes := make([]attribute.Attribute, 0)
+	for _, columnName := range columns {
+		column, declared := cq.column(columnName)
+		if !declared || columnSourceTypes[column.Type] != metric.ATTRIBUTE {
+			continue
+		}
+		name := cq.Prefix + column.outputName(columnName)
+		if value, ok := dbMetrics[name].value.(string); ok {
+			attributes = append(attributes, attribute.Attribute{Key: name, Value: value})
+		}
+	}
+	sort.Slice(attributes, func(i, j int) bool { return attributes[i].Key < attributes[j].Key })
+	return attributes
+}
+
+// customDatabaseSetsLock serializes the custom queries setting metrics on the same database sample
+var customDatabaseSetsLock sync.Mutex
+
+// lastRunKeyPrefix is the prefix of the key storing when a custom query with an interval last ran
+const lastRunKeyPrefix = "customQuery.lastRun."
+
+// validate returns an error if the interval, offset, entity, database patterns, event type, attributes or columns
+// of the query are not valid
+func (cq customQuery) validate() error {
+	if _, err := database.NewNameFilter(cq.DatabaseInclude, cq.DatabaseExclude, true); err != nil {
+		return err
+	}
+
+	for columnName, column := range cq.Columns {
+		if err := column.validate(columnName); err != nil {
+			return err
+		}
+	}
+
+	for key := range cq.Attributes {
+		for _, reserved := range reservedAttributes {
+			if strings.EqualFold(key, reserved) {
+				return fmt.Errorf("attribute %s is set by the integration", key)
+			}
+		}
+		if strings.TrimSpace(key) == "" {
+			return errors.New("attribute names cannot be empty")
+		}
+	}
+
+	switch {
+	case cq.Entity != "" && cq.Entity != customQueryEntityInstance && cq.Entity != customQueryEntityDatabase:
+		return fmt.Errorf("entity must be %s or %s", customQueryEntityInstance, customQueryEntityDatabase)
+	case cq.EventType != "" && cq.Entity == customQueryEntityDatabase:
+		return errors.New("event_type cannot be set for queries reported on database entities, their rows are set on MssqlDatabaseSample")
+	case cq.EventType != "" && !eventTypeRegex.MatchString(cq.EventType):
+		return fmt.Errorf("event_type %s must start with a letter and only have letters, digits, underscores and colons", cq.EventType)
+	case cq.EventType != "" && cq.EventType != defaultCustomQueryEventType && reservedEventTypeRegex.MatchString(cq.EventType):
+		return fmt.Errorf("event_type %s is reserved for the samples of the integration, names like Mssql*Sample cannot be used", cq.EventType)
+	case cq.Interval < 0 || (cq.Interval > 0 && cq.Interval < time.Second):
+		return errors.New("interval must be a duration of at least a second, Ex: 5m")
+	case cq.Interval >= state.TTL:
+		return fmt.Errorf("interval must be shorter than %s, the time the last run of the query is kept", state.TTL)
+	case cq.Offset < 0 || (cq.Offset > 0 && cq.Offset >= cq.Interval):
+		return errors.New("offset must be a duration shorter than the interval")
+	}
+	return nil
+}
+
+// due returns true if the query runs in this collection. A query with an interval runs once in each interval,
+// aligned to the offset, so it is due until its run is recorded. Without the state it runs on every collection.
+func (cq customQuery) due(instanceState *state.State, now time.Time) bool {
+	if cq.Interval == 0 || instanceState == nil {
+		return true
+	}
+
+	slot := func(t time.Time) int64 {
+		return int64(t.Add(-cq.Offset).Sub(time.Unix(0, 0)) / cq.Interval)
+	}
+	lastRun, ok := instanceState.Watermark(cq.lastRunKey())
+	return !ok || slot(lastRun) < slot(now)
+}
+
+// recordRun records in the state of the instance that a query with an interval ran successfully
+func (cq customQuery) recordRun(instanceState *state.State, now time.Time) {
+	if cq.Interval == 0 || instanceState == nil {
+		return
+	}
+	instanceState.SetWatermark(cq.lastRunKey(), now)
+}
+
+// lastRunKey returns the key storing when the query last ran
+func (cq customQuery) lastRunKey() string {
+	databases := strings.Join(cq.Databases, ",")
+	if cq.DatabaseInclude != "" || cq.DatabaseExclude != "" {
+		databases += "\x00" + cq.DatabaseInclude + "\x00" + cq.DatabaseExclude
+	}
+	sum := sha256.Sum256([]byte(databases + "\x00" + cq.Prefix + "\x00" + cq.Query))
+	return lastRunKeyPrefix + hex.EncodeToString(sum[:8])
 }
 
 // customQueryMetricValue represents a metric value fetched from the results of a custom query
@@ -37,7 +372,7 @@ var errMissingMetricValueCustomQuery = errors.New("missing 'metric_value' for cu
 var errMissingMetricNameCustomQuery = errors.New("missing 'metric_name' for custom query")
 
 // PopulateInstanceMetrics creates instance-level metrics
-func PopulateInstanceMetrics(instanceEntity *integration.Entity, connection *connection.SQLConnection, arguments args.ArgumentList) {
+func PopulateInstanceMetrics(instanceEntity *integration.Entity, connection *connection.SQLConnection, arguments args.ArgumentList, telemetry *Telemetry) {
 	metricSet := instanceEntity.NewMetricSet("MssqlInstanceSample",
 		attribute.Attribute{Key: "displayName", Value: instanceEntity.Metadata.Name},
 		attribute.Attribute{Key: "entityName", Value: instanceEntity.Metadata.Namespace + ":" + instanceEntity.Metadata.Name},
@@ -47,14 +382,25 @@ func PopulateInstanceMetrics(instanceEntity *integration.Entity, connection *con
 	collectionList := instanceDefinitions
 	if arguments.EnableBufferMetrics {
 		collectionList = append(collectionList, instanceBufferDefinitions...)
+	} else {
+		telemetry.recordDisabled(instanceBufferDefinitions...)
 	}
 	if arguments.EnableDiskMetricsInBytes {
 		collectionList = append(collectionList, diskMetricInBytesDefination...)
+	} else {
+		telemetry.recordDisabled(diskMetricInBytesDefination...)
 	}
 
-	for _, queryDef := range collectionList {
+	collectionList = serverDefinitions(connection, telemetry, collectionList)
+	for index, queryDef := range collectionList {
+		if err := connection.Err(); err != nil {
+			log.Warn("Skipping remaining instance queries: %s", err.Error())
+			telemetry.recordSkipped(collectionList[index:]...)
+			return
+		}
+
 		models := queryDef.GetDataModels()
-		if err := connection.Query(models, queryDef.GetQuery()); err != nil {
+		if err := runQueryDefinition(connection, telemetry, queryDef, queryDef.GetQuery(), models); err != nil {
 			log.Error("Could not execute instance query: %s", err.Error())
 			continue
 		}
@@ -74,26 +420,85 @@ func PopulateInstanceMetrics(instanceEntity *integration.Entity, connection *con
 		}
 	}
 
-	populateWaitTimeMetrics(instanceEntity, connection)
+}
 
+// PopulateCustomQueryMetrics runs the custom query of custom_metrics_query or the ones of custom_metrics_config.
+// The time each query with an interval last ran successfully is kept in the state of the instance, so it only runs once
+// per interval. Queries run in several databases are recorded when they succeed in any of them.
+// The rows of queries on database entities are set on the MssqlDatabaseSample of dbSetLookup, whose databases
+// are the ones queries with "*" or patterns run in. Up to max_concurrent_database_queries queries run at the same time,
+// counting each database a query runs in.
+func PopulateCustomQueryMetrics(instanceEntity *integration.Entity, connection *connection.SQLConnection, arguments args.ArgumentList, dbSetLookup database.DBMetricSetLookup, instanceState *state.State, telemetry *Telemetry) {
+	obfuscator, _ := obfuscation.New(arguments.QueryTextMode)
 	if len(arguments.CustomMetricsQuery) > 0 {
 		log.Debug("Arguments custom metrics query: %s", arguments.CustomMetricsQuery)
-		populateCustomMetrics(instanceEntity, connection, customQuery{Query: arguments.CustomMetricsQuery})
+		populateCustomMetrics(instanceEntity, connection, telemetry, customQuery{Query: arguments.CustomMetricsQuery, obfuscator: obfuscator, telemetryName: customMetricsQueryTelemetry})
 	} else if len(arguments.CustomMetricsConfig) > 0 {
 		queries, err := parseCustomQueries(arguments)
 		if err != nil {
 			log.Error("Failed to parse custom queries: %s", err)
+			telemetry.recordQuery(customMetricsConfigTelemetry, 0, 0, err)
 		}
 		log.Debug("Parsed custom queries: %+v", queries)
+		now := time.Now()
+
+		// every query to run, once for each database of the queries run in several of them
+		type customQueryRun struct {
+			index int
+			query customQuery
+		}
+		runs := make([]customQueryRun, 0, len(queries))
+		for index, query := range queries {
+			if !query.due(instanceState, now) {
+				log.Debug("Skipping custom query not due yet: %s", query.Query)
+				continue
+			}
+
+			query.obfuscator = obfuscator
+			query.dbSetLookup = dbSetLookup
+			if !query.fansOut() {
+				runs = append(runs, customQueryRun{index, query})
+				continue
+			}
+
+			dbNames := query.databases(dbSetLookup.GetDBNames())
+			if len(dbNames) == 0 {
+				log.Debug("Skipping custom query without databases to run in: %s", query.Query)
+			}
+			for _, dbName := range dbNames {
+				dbQuery := query
+				dbQuery.Database = dbName
+				runs = append(runs, customQueryRun{index, dbQuery})
+			}
+		}
+
+		succeeded := make([]int32, len(queries))
+		workers := arguments.MaxConcurrentDatabaseQueries
+		if workers < 1 {
+			workers = 1
+		}
+		semaphore := make(chan struct{}, workers)
 		var wg sync.WaitGroup
-		for _, query := range queries {
+		for _, run := range runs {
 			wg.Add(1)
-			go func(query customQuery) {
-				defer wg.Done()
-				populateCustomMetrics(instanceEntity, connection, query)
-			}(query)
+			semaphore <- struct{}{}
+			go func(run customQueryRun) {
+				defer func() {
+					<-semaphore
+					wg.Done()
+				}()
+				if populateCustomMetrics(instanceEntity, connection, telemetry, run.query) {
+					atomic.StoreInt32(&succeeded[run.index], 1)
+				}
+			}(run)
 		}
 		wg.Wait()
+
+		for index, query := range queries {
+			if succeeded[index] == 1 {
+				query.recordRun(instanceState, now)
+			}
+		}
 	}
 }
 
@@ -110,65 +515,60 @@ func parseCustomQueries(arguments args.ArgumentList) ([]customQuery, error) {
 		return nil, fmt.Errorf("failed to parse custom_metrics_config: %s", err)
 	}
 
+	for index := range c.Queries {
+		query := &c.Queries[index]
+		if err := query.validate(); err != nil {
+			return nil, fmt.Errorf("invalid query %d of custom_metrics_config: %s", index+1, err)
+		}
+		if !query.fansOut() && len(query.Databases) == 1 {
+			query.Database = query.Databases[0]
+		}
+		query.telemetryName = query.defaultTelemetryName(index)
+	}
+
 	return c.Queries, nil
 }
 
-func populateWaitTimeMetrics(instanceEntity *integration.Entity, connection *connection.SQLConnection) {
-	models := make([]waitTimeModel, 0)
-	if err := connection.Query(&models, waitTimeQuery); err != nil {
-		log.Error("Could not execute query: %s", err.Error())
-		return
+// defaultTelemetryName returns the name the query at index of custom_metrics_config is recorded with in the
+// telemetry: its event type, its prefix or else its position. Queries with the same name are aggregated.
+func (cq customQuery) defaultTelemetryName(index int) string {
+	switch {
+	case cq.EventType != "":
+		return customQueryTelemetryPrefix + cq.EventType
+	case cq.Prefix != "":
+		return customQueryTelemetryPrefix + cq.Prefix
 	}
+	return fmt.Sprintf("%squery_%d", customQueryTelemetryPrefix, index+1)
+}
 
-	for _, model := range models {
-		metricSet := instanceEntity.NewMetricSet("MssqlWaitSample",
-			attribute.Attribute{Key: "displayName", Value: instanceEntity.Metadata.Name},
-			attribute.Attribute{Key: "entityName", Value: instanceEntity.Metadata.Namespace + ":" + instanceEntity.Metadata.Name},
-			attribute.Attribute{Key: "waitType", Value: *model.WaitType},
-			attribute.Attribute{Key: "host", Value: connection.Host},
-			attribute.Attribute{Key: "instance", Value: instanceEntity.Metadata.Name},
-		)
-
-		metrics := []struct {
-			metricName  string
-			metricValue int64
-			metricType  metric.SourceType
-		}{
-			{
-				"system.waitTimeCount", *model.WaitCount, metric.GAUGE,
-			},
-			{
-				"system.waitTimeInMillisecondsPerSecond", *model.WaitTime, metric.GAUGE,
-			},
-		}
-
-		for _, metric := range metrics {
-			err := metricSet.SetMetric(metric.metricName, metric.metricValue, metric.metricType)
-			if err != nil {
-				log.Error("Could not set wait time metric '%s' for wait type '%s': %s", metric.metricName, model.WaitType, err.Error())
-			}
-		}
+// Execute one or more custom queries, returning false if the query or its rows could not be read.
+// Each run is recorded in telemetry, with the rows returned or the error.
+func populateCustomMetrics(instanceEntity *integration.Entity, connection *connection.SQLConnection, telemetry *Telemetry, query customQuery) bool {
+	if err := connection.Err(); err != nil {
+		log.Warn("Skipping custom query: %s", err.Error())
+		telemetry.recordSkipped(query.definition())
+		return false
 	}
-}
 
-// Execute one or more custom queries
-func populateCustomMetrics(instanceEntity *integration.Entity, connection *connection.SQLConnection, query customQuery) {
 	var prefix string
 	if len(query.Database) > 0 {
-		prefix = "USE " + query.Database + "; "
+		prefix = useDatabase(query.Database)
 	}
 
 	log.Debug("Running custom query: %+v", query)
 
+	start := time.Now()
 	rows, err := connection.Queryx(prefix + query.Query)
 	if err != nil {
 		log.Error("Could not execute custom query: %s", err)
-		return
+		telemetry.recordQuery(query.telemetryName, time.Since(start), 0, err)
+		return false
 	}
 	columns, err := rows.Columns()
 	if err != nil {
 		log.Error("Could not fetch types information from custom query", err)
-		return
+		telemetry.recordQuery(query.telemetryName, time.Since(start), 0, err)
+		return false
 	}
 
 	defer func() {
@@ -176,6 +576,7 @@ func populateCustomMetrics(instanceEntity *integration.Entity, connection *conne
 	}()
 
 	var rowCount = 0
+	dbRows := make(map[string]bool)
 	for rows.Next() {
 		rowCount++
 		values := make([]string, len(columns))                 // All values are represented as strings (the corresponding conversion is handled while scanning)
@@ -185,7 +586,18 @@ func populateCustomMetrics(instanceEntity *integration.Entity, connection *conne
 		}
 		if err := rows.Scan(valuesForScanning...); err != nil {
 			log.Error("Failed to scan custom query row: %s", err)
-			return
+			telemetry.recordQuery(query.telemetryName, time.Since(start), rowCount, err)
+			return false
+		}
+
+		dbMetrics, err := metricsFromCustomQueryRow(values, columns, query)
+		if err != nil {
+			log.Error("Error fetching metrics from query %s (query: %s)", err, query.Query)
+		}
+
+		if query.Entity == customQueryEntityDatabase {
+			setCustomDatabaseMetrics(query, columns, values, dbMetrics, dbRows)
+			continue
 		}
 
 		attributes := []attribute.Attribute{
@@ -197,13 +609,14 @@ func populateCustomMetrics(instanceEntity *integration.Entity, connection *conne
 		if len(query.Database) > 0 {
 			attributes = append(attributes, attribute.Attribute{Key: "database", Value: query.Database})
 		}
-		ms := instanceEntity.NewMetricSet("MssqlCustomQuerySample", attributes...)
-
-		dbMetrics, err := metricsFromCustomQueryRow(values, columns, query)
-		if err != nil {
-			log.Error("Error fetching metrics from query %s (query: %s)", err, query.Query)
-		}
+		attributes = append(attributes, query.staticAttributes()...)
+		rowAttributes := query.rowAttributes(columns, dbMetrics)
+		attributes = append(attributes, rowAttributes...)
+		ms := instanceEntity.NewMetricSet(query.eventType(), attributes...)
 		for name, dbMetric := range dbMetrics {
+			if isAttribute(rowAttributes, name) {
+				continue
+			}
 			err = ms.SetMetric(name, dbMetric.value, dbMetric.sourceType)
 			if err != nil {
 				log.Error("Failed to set metric: %s", err)
@@ -220,6 +633,72 @@ func populateCustomMetrics(instanceEntity *integration.Entity, connection *conne
 
 	if err := rows.Err(); err != nil {
 		log.Error("Error iterating rows: %s", err)
+		telemetry.recordQuery(query.telemetryName, time.Since(start), rowCount, err)
+		return false
+	}
+	telemetry.recordQuery(query.telemetryName, time.Since(start), rowCount, nil)
+	return true
+}
+
+// isAttribute returns true if name is the key of one of attributes
+func isAttribute(attributes []attribute.Attribute, name string) bool {
+	for _, attr := range attributes {
+		if attr.Key == name {
+			return true
+		}
+	}
+	return false
+}
+
+// useDatabase returns the statement switching to a database, quoting its name
+func useDatabase(dbName string) string {
+	return "USE [" + strings.Replace(dbName, "]", "]]", -1) + "]; "
+}
+
+// setCustomDatabaseMetrics sets the metrics and static attributes of a row on the MssqlDatabaseSample of its database:
+// the one of the db_name column or else the database of the query. Rows of databases not monitored are skipped, as are
+// the rows of a database already in dbRows, as a sample only has one row of each query.
+func setCustomDatabaseMetrics(query customQuery, columns, row []string, dbMetrics map[string]customQueryMetricValue, dbRows map[string]bool) {
+	dbName := query.Database
+	for i, columnName := range columns {
+		if columnName == dbNameColumn {
+			dbName = row[i]
+		}
+	}
+
+	ms, ok := query.dbSetLookup[dbName]
+	if !ok {
+		log.Debug("Skipping custom query row of database '%s', which is not monitored", dbName)
+		return
+	}
+	if dbRows[dbName] {
+		log.Warn("Skipping custom query row of database '%s', the query returned more than one row for it: %s", dbName, query.Query)
+		return
+	}
+	dbRows[dbName] = true
+
+	customDatabaseSetsLock.Lock()
+	defer customDatabaseSetsLock.Unlock()
+	for _, attr := range query.staticAttributes() {
+		setCustomDatabaseMetric(ms, dbName, attr.Key, attr.Value, metric.ATTRIBUTE)
+	}
+	for name, dbMetric := range dbMetrics {
+		setCustomDatabaseMetric(ms, dbName, name, dbMetric.value, dbMetric.sourceType)
+	}
+}
+
+// setCustomDatabaseMetric sets a metric of a custom query on the sample of a database, unless the sample already
+// has it, such as the metrics of the integration or the ones of other queries. Use a prefix to tell them apart.
+func setCustomDatabaseMetric(ms *metric.Set, dbName, name string, value any, sourceType metric.SourceType) {
+	if current, ok := ms.Metrics[name]; ok {
+		// the same static attributes can be set by several queries
+		if sourceType != metric.ATTRIBUTE || current != value {
+			log.Warn("Skipping custom query metric '%s' of database '%s', which is already set", name, dbName)
+		}
+		return
+	}
+	if err := ms.SetMetric(name, value, sourceType); err != nil {
+		log.Error("Failed to set metric: %s", err)
 	}
 }
 
@@ -246,11 +725,37 @@ func metricsFromCustomQueryRow(row []string, columns []string, query customQuery
 			metricType = row[i]
 		case "metric_value":
 			metricValue = row[i]
-		// The rest of the values are taken as metrics/attributes with automatically detected type.
+		// The database of the rows reported on database entities is the entity itself
+		case dbNameColumn:
+			if query.Entity == customQueryEntityDatabase {
+				continue
+			}
+			fallthrough
+		// The rest of the values are taken as metrics/attributes with the declared type, or else automatically detected.
 		default:
-			name := query.Prefix + columnName
+			column, declared := query.column(columnName)
+			if declared && column.Type == columnTypeIgnore {
+				continue
+			}
+
 			value := row[i]
-			metrics[name] = customQueryMetricValue{value: value, sourceType: detectMetricType(value)}
+			if query.isQueryTextColumn(columnName) {
+				text, ok := query.obfuscator.Apply(value)
+				if !ok {
+					continue
+				}
+				value = text
+			}
+
+			if declared {
+				sourceType, ok := columnSourceTypes[column.Type]
+				if !ok {
+					sourceType = detectMetricType(value)
+				}
+				metrics[query.Prefix+column.outputName(columnName)] = customQueryMetricValue{value: value, sourceType: sourceType}
+				continue
+			}
+			metrics[query.Prefix+columnName] = customQueryMetricValue{value: value, sourceType: detectMetricType(value)}
 		}
 	}
 
@@ -294,12 +799,22 @@ func metricFromTargetColumns(metricValue, metricName, metricType string, query c
 	return &customQueryMetricValue{value: metricValue, sourceType: sourceType}, nil
 }
 
-// PopulateDatabaseMetrics collects per-database metrics
-func PopulateDatabaseMetrics(i *integration.Integration, instanceName string, connection *connection.SQLConnection, arguments args.ArgumentList) error {
+// databaseDiscoveryTelemetry is the name the discovery of the databases is recorded with in the telemetry
+const databaseDiscoveryTelemetry = "database_discovery"
+
+// PopulateDatabaseMetrics collects per-database metrics, returning the MssqlDatabaseSample of each database
+func PopulateDatabaseMetrics(i *integration.Integration, instanceName string, connection *connection.SQLConnection, arguments args.ArgumentList, telemetry *Telemetry) (database.DBMetricSetLookup, error) {
+	filter, err := database.NewNameFilter(arguments.DatabaseInclude, arguments.DatabaseExclude, arguments.IncludeSystemDatabases)
+	if err != nil {
+		return nil, err
+	}
+
 	// create database entities
-	dbEntities, err := database.CreateDatabaseEntities(i, connection, instanceName)
+	start := time.Now()
+	dbEntities, err := database.CreateDatabaseEntities(i, connection, instanceName, filter)
+	telemetry.recordQuery(databaseDiscoveryTelemetry, time.Since(start), len(dbEntities), err)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// create database entities lookup for fast metric set
@@ -312,47 +827,144 @@ func PopulateDatabaseMetrics(i *integration.Integration, instanceName string, co
 	go dbMetricPopulator(dbSetLookup, modelChan, &wg)
 
 	// run queries that are not specific to a database
-	processGeneralDBDefinitions(connection, modelChan)
+	processServerDBDefinitions(connection, telemetry, databaseDefinitions, filter, modelChan)
 
 	// run queries that are not specific to a database
 	if arguments.EnableBufferMetrics {
-		processDBBufferDefinitions(connection, modelChan)
+		processServerDBDefinitions(connection, telemetry, databaseBufferDefinitions, filter, modelChan)
+	} else {
+		telemetry.recordDisabled(databaseBufferDefinitions...)
+	}
+
+	// run queries on the backup history of the databases
+	if arguments.EnableDatabaseBackupMetrics {
+		processServerDBDefinitions(connection, telemetry, databaseBackupDefinitions, filter, modelChan)
+	} else {
+		telemetry.recordDisabled(databaseBackupDefinitions...)
 	}
 
 	// run queries that are specific to a database
 	if arguments.EnableDatabaseReserveMetrics {
-		processSpecificDBDefinitions(connection, dbSetLookup.GetDBNames(), modelChan)
+		processSpecificDBDefinitions(connection, telemetry, dbSetLookup.GetDBNames(), arguments.MaxConcurrentDatabaseQueries, modelChan)
+	} else {
+		telemetry.recordDisabled(specificDatabaseDefinitions...)
 	}
 
+	// run queries of each Azure SQL Database
+	processAzureDatabaseDefinitions(connection, telemetry, arguments, dbSetLookup.GetDBNames(), modelChan)
+
 	close(modelChan)
 	wg.Wait()
 
-	return nil
+	if err := connection.Err(); err != nil {
+		log.Warn("Database metrics collection was interrupted: %s", err.Error())
+	}
+
+	return dbSetLookup, nil
 }
 
-func processGeneralDBDefinitions(con *connection.SQLConnection, modelChan chan<- interface{}) {
-	for _, queryDef := range databaseDefinitions {
-		makeDBQuery(con, queryDef.GetQuery(), queryDef.GetDataModels(), modelChan)
+// processServerDBDefinitions runs queries returning a row for each database of the server
+func processServerDBDefinitions(con *connection.SQLConnection, telemetry *Telemetry, dbDefinitions []*QueryDefinition, filter *database.NameFilter, modelChan chan<- interface{}) {
+	definitions := serverDefinitions(con, telemetry, dbDefinitions)
+	for index, queryDef := range definitions {
+		if con.Err() != nil {
+			telemetry.recordSkipped(definitions[index:]...)
+			return
+		}
+		makeDBQuery(con, telemetry, queryDef, queryDef.GetQuery(excludedDatabasesReplace(filter)), modelChan)
 	}
 }
 
-func processDBBufferDefinitions(con *connection.SQLConnection, modelChan chan<- interface{}) {
-	for _, queryDef := range databaseBufferDefinitions {
-		makeDBQuery(con, queryDef.GetQuery(), queryDef.GetDataModels(), modelChan)
+// processSpecificDBDefinitions runs the queries specific to each database,
+// querying up to maxConcurrency databases at the same time
+func processSpecificDBDefinitions(con *connection.SQLConnection, telemetry *Telemetry, dbNames []string, maxConcurrency int, modelChan chan<- interface{}) {
+	definitions := serverDefinitions(con, telemetry, specificDatabaseDefinitions)
+	if len(definitions) == 0 {
+		return
+	}
+
+	forEachDatabase(con, dbNames, maxConcurrency, func(dbName string) {
+		for _, queryDef := range definitions {
+			makeDBQuery(con, telemetry, queryDef, queryDef.GetQuery(dbNameReplace(dbName)), modelChan)
+		}
+	})
+
+	// definitions that could not run for any database before the collection was cancelled
+	if con.Err() != nil {
+		telemetry.recordSkipped(definitions...)
 	}
 }
 
-func processSpecificDBDefinitions(con *connection.SQLConnection, dbNames []string, modelChan chan<- interface{}) {
-	for _, queryDef := range specificDatabaseDefinitions {
-		for _, dbName := range dbNames {
-			query := queryDef.GetQuery(dbNameReplace(dbName))
-			makeDBQuery(con, query, queryDef.GetDataModels(), modelChan)
+// processAzureDatabaseDefinitions runs the queries of each Azure SQL Database through a connection to it,
+// as Azure SQL Database does not allow switching databases with USE
+func processAzureDatabaseDefinitions(con *connection.SQLConnection, telemetry *Telemetry, arguments args.ArgumentList, dbNames []string, modelChan chan<- interface{}) {
+	// they are left out of the telemetry of other servers, as none of them ever runs there
+	if con.ServerInfo == nil || !con.ServerInfo.IsAzureSQLDatabase() {
+		return
+	}
+
+	definitions := azureDatabaseDefinitions
+	if arguments.EnableDatabaseReserveMetrics {
+		definitions = append(definitions, azureDatabaseReserveDefinitions...)
+	} else {
+		telemetry.recordDisabled(azureDatabaseReserveDefinitions...)
+	}
+	definitions = serverDefinitions(con, telemetry, definitions)
+
+	forEachDatabase(con, dbNames, arguments.MaxConcurrentDatabaseQueries, func(dbName string) {
+		dbCon, err := con.ForDatabase(&arguments, dbName)
+		if err != nil {
+			log.Error("Unable to connect to database '%s': %s", dbName, err.Error())
+			return
+		}
+		defer dbCon.Close()
+
+		for _, queryDef := range definitions {
+			makeDBQuery(dbCon, telemetry, queryDef, queryDef.GetQuery(), modelChan)
 		}
+	})
+
+	if con.Err() != nil {
+		telemetry.recordSkipped(definitions...)
+	}
+}
+
+// forEachDatabase calls collect for every database using a pool of workers, so up to maxConcurrency
+// databases are collected at the same time. Databases left once the collection is cancelled are not collected.
+func forEachDatabase(con *connection.SQLConnection, dbNames []string, maxConcurrency int, collect func(dbName string)) {
+	workers := maxConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(dbNames) {
+		workers = len(dbNames)
+	}
+
+	dbNameChan := make(chan string)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dbName := range dbNameChan {
+				if con.Err() != nil {
+					continue
+				}
+				collect(dbName)
+			}
+		}()
+	}
+
+	for _, dbName := range dbNames {
+		dbNameChan <- dbName
 	}
+	close(dbNameChan)
+	wg.Wait()
 }
 
-func makeDBQuery(con *connection.SQLConnection, query string, models interface{}, modelChan chan<- interface{}) {
-	if err := con.Query(models, query); err != nil {
+func makeDBQuery(con *connection.SQLConnection, telemetry *Telemetry, queryDef *QueryDefinition, query string, modelChan chan<- interface{}) {
+	models := queryDef.GetDataModels()
+	if err := runQueryDefinition(con, telemetry, queryDef, query, models); err != nil {
 		log.Error("Encountered the following error: %s. Running query '%s'", err.Error(), query)
 		return
 	}
@@ -361,6 +973,37 @@ func makeDBQuery(con *connection.SQLConnection, query string, models interface{}
 	sendModelsToPopulator(modelChan, models)
 }
 
+// serverDefinitions returns the definitions, or their variants, supported by the server of con.
+// The rest are recorded as unsupported.
+func serverDefinitions(con *connection.SQLConnection, telemetry *Telemetry, definitions []*QueryDefinition) []*QueryDefinition {
+	supported := make([]*QueryDefinition, 0, len(definitions))
+	for _, queryDef := range definitions {
+		resolved, ok := queryDef.forServer(con.ServerInfo)
+		if !ok {
+			log.Debug("Skipping query '%s' not supported by the server", queryDef.GetName())
+			telemetry.recordUnsupported(queryDef)
+			continue
+		}
+		supported = append(supported, resolved)
+	}
+
+	return supported
+}
+
+// runQueryDefinition runs query, built from queryDef, loading the results into models and recording its telemetry
+func runQueryDefinition(con *connection.SQLConnection, telemetry *Telemetry, queryDef *QueryDefinition, query string, models interface{}) error {
+	start := time.Now()
+	err := con.Query(models, query)
+
+	rows := 0
+	if err == nil {
+		rows = modelsLen(models)
+	}
+	telemetry.recordQuery(queryDef.GetName(), time.Since(start), rows, err)
+
+	return err
+}
+
 func sendModelsToPopulator(modelChan chan<- interface{}, models interface{}) {
 	v := reflect.ValueOf(models)
 	vp := reflect.Indirect(v)
@@ -382,6 +1025,11 @@ func dbMetricPopulator(dbSetLookup database.DBMetricSetLookup, modelChan <-chan
 
 		metricSet, ok := dbSetLookup.MetricSetFromModel(model)
 		if !ok {
+			// databases excluded from monitoring have no entity in the lookup
+			if modeler, isModeler := model.(database.DataModeler); isModeler && modeler.GetDBName() != "" {
+				log.Debug("Skipping metrics for unmonitored database '%s'", modeler.GetDBName())
+				continue
+			}
 			log.Error("Unable to determine database name, %+v", model)
 			continue
 		}
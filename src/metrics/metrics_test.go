@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"context"
 	"flag"
 	"os"
 	"path/filepath"
@@ -9,10 +10,14 @@ import (
 	"time"
 
 	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
 	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/persist"
 	"github.com/newrelic/nri-mssql/src/args"
 	"github.com/newrelic/nri-mssql/src/connection"
 	"github.com/newrelic/nri-mssql/src/database"
+	"github.com/newrelic/nri-mssql/src/obfuscation"
+	"github.com/newrelic/nri-mssql/src/state"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
 )
@@ -82,7 +87,8 @@ func Test_populateDatabaseMetrics(t *testing.T) {
 	args := args.ArgumentList{
 		EnableBufferMetrics: true,
 	}
-	assert.NoError(t, PopulateDatabaseMetrics(i, "MSSQL", conn, args))
+	_, err := PopulateDatabaseMetrics(i, "MSSQL", conn, args, nil)
+	assert.NoError(t, err)
 
 	actual, _ := i.MarshalJSON()
 	expectedFile := filepath.Join("..", "testdata", "databaseMetrics.json.golden")
@@ -90,6 +96,94 @@ func Test_populateDatabaseMetrics(t *testing.T) {
 	checkAgainstFile(t, actual, expectedFile)
 }
 
+func Test_populateDatabaseMetrics_Filtered(t *testing.T) {
+	i, _ := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	databaseRows := sqlmock.NewRows([]string{"db_name"}).
+		AddRow("master").
+		AddRow("otherdb")
+	logGrowthRows := sqlmock.NewRows([]string{"db_name", "log_growth"}).
+		AddRow("master", 0).
+		AddRow("otherdb", 1)
+
+	mock.ExpectQuery(`select name as db_name from sys\.databases`).
+		WillReturnRows(databaseRows)
+
+	mock.ExpectQuery(`select\s+RTRIM\(t1\.instance_name\).*`).
+		WillReturnRows(logGrowthRows)
+
+	mock.ExpectClose()
+
+	args := args.ArgumentList{
+		DatabaseExclude: "other*",
+	}
+	_, err := PopulateDatabaseMetrics(i, "MSSQL", conn, args, nil)
+	assert.NoError(t, err)
+
+	// the test instance entity plus the single monitored database
+	assert.Len(t, i.Entities, 2)
+	dbEntity := i.Entities[1]
+	assert.Equal(t, "master", dbEntity.Metadata.Name)
+	assert.Len(t, dbEntity.Metrics, 1)
+	assert.Equal(t, float64(0), dbEntity.Metrics[0].Metrics["log.transactionGrowth"])
+}
+
+func Test_populateDatabaseMetrics_InvalidFilter(t *testing.T) {
+	i, _ := createTestEntity(t)
+
+	conn, _ := connection.CreateMockSQL(t)
+
+	args := args.ArgumentList{
+		DatabaseInclude: "/[a-/",
+	}
+	_, err := PopulateDatabaseMetrics(i, "MSSQL", conn, args, nil)
+	assert.Error(t, err)
+}
+
+func Test_populateDatabaseMetrics_ConcurrentReserveSpace(t *testing.T) {
+	i, _ := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	// reserve space queries run concurrently so their order is not known
+	mock.MatchExpectationsInOrder(false)
+
+	dbNames := []string{"db1", "db2", "db3", "db4", "db5"}
+	databaseRows := sqlmock.NewRows([]string{"db_name"})
+	for _, dbName := range dbNames {
+		databaseRows.AddRow(dbName)
+	}
+	mock.ExpectQuery(`select name as db_name from sys\.databases`).
+		WillReturnRows(databaseRows)
+	mock.ExpectQuery(`select\s+RTRIM\(t1\.instance_name\).*`).
+		WillReturnRows(sqlmock.NewRows([]string{"db_name", "log_growth"}))
+	for _, dbName := range dbNames {
+		mock.ExpectQuery(`USE "` + dbName + `"`).
+			WillReturnRows(sqlmock.NewRows([]string{"db_name", "reserved_space", "reserved_space_not_used"}).
+				AddRow(dbName, 2048, 1024))
+	}
+
+	args := args.ArgumentList{
+		EnableDatabaseReserveMetrics: true,
+		MaxConcurrentDatabaseQueries: 3,
+	}
+	_, err := PopulateDatabaseMetrics(i, "MSSQL", conn, args, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// the test instance entity plus one entity per database
+	assert.Len(t, i.Entities, len(dbNames)+1)
+	for _, dbEntity := range i.Entities[1:] {
+		assert.Len(t, dbEntity.Metrics, 1)
+		assert.Equal(t, float64(2048), dbEntity.Metrics[0].Metrics["pageFileTotal"], dbEntity.Metadata.Name)
+		assert.Equal(t, float64(1024), dbEntity.Metrics[0].Metrics["pageFileAvailable"], dbEntity.Metadata.Name)
+	}
+}
+
 func Test_dbMetric_Populator_DBNameError(t *testing.T) {
 	modelChan := make(chan interface{}, 10)
 	var wg sync.WaitGroup
@@ -162,7 +256,7 @@ func Test_populateInstanceMetrics(t *testing.T) {
 		EnableBufferMetrics:      true,
 		EnableDiskMetricsInBytes: true,
 	}
-	PopulateInstanceMetrics(e, conn, args)
+	PopulateInstanceMetrics(e, conn, args, nil)
 
 	actual, _ := i.MarshalJSON()
 	expectedFile := filepath.Join("..", "testdata", "perfCounter.json.golden")
@@ -171,50 +265,46 @@ func Test_populateInstanceMetrics(t *testing.T) {
 	checkAgainstFile(t, actual, expectedFile)
 }
 
-func Test_populateInstanceMetrics_NoReturn(t *testing.T) {
+func Test_populateInstanceMetrics_CollectionCancelled(t *testing.T) {
 	i, e := createTestEntity(t)
 
-	conn, mock := connection.CreateMockSQL(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	conn, mock := connection.CreateMockSQLContext(t, ctx)
 	defer conn.Close()
 
-	perfCounterRows := sqlmock.NewRows([]string{"buffer_cache_hit_ratio", "buffer_pool_hit_percent", "sql_compilations", "sql_recompilations", "user_connections", "lock_wait_time_ms", "page_splits_sec", "checkpoint_pages_sec", "deadlocks_sec", "user_errors", "kill_connection_errors", "batch_request_sec", "page_life_expectancy_ms", "transactions_sec", "forced_parameterizations_sec"})
+	// the collection timeout is reached while running the first query
+	mock.ExpectQuery(`.*`).
+		WillReturnRows(sqlmock.NewRows([]string{"sql_compilations"}).AddRow(1)).
+		WillDelayFor(time.Second)
+	time.AfterFunc(10*time.Millisecond, cancel)
 
-	// only match the performance counter query
-	mock.ExpectQuery(`SELECT\s+t1.cntr_value AS buffer_cache_hit_ratio.*`).WillReturnRows(perfCounterRows)
-	mock.ExpectClose()
+	PopulateInstanceMetrics(e, conn, args.ArgumentList{}, nil)
 
-	args := args.ArgumentList{
-		EnableBufferMetrics: true,
-	}
-	PopulateInstanceMetrics(e, conn, args)
-
-	actual, _ := i.MarshalJSON()
-	expectedFile := filepath.Join("..", "testdata", "empty.json.golden")
-	assert.NoError(t, updateGoldenFile(actual, expectedFile))
-
-	checkAgainstFile(t, actual, expectedFile)
+	// no other query is run once cancelled and the instance sample is still reported
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Len(t, i.Entities[0].Metrics, 1)
+	assert.Equal(t, "MssqlInstanceSample", i.Entities[0].Metrics[0].Metrics["event_type"])
 }
 
-func Test_populateWaitTimeMetrics(t *testing.T) {
+func Test_populateInstanceMetrics_NoReturn(t *testing.T) {
 	i, e := createTestEntity(t)
 
 	conn, mock := connection.CreateMockSQL(t)
 	defer conn.Close()
 
-	waitTimeRows := sqlmock.NewRows([]string{"wait_type", "wait_time", "waiting_tasks_count"}).
-		AddRow("LCK_M_S", 638, 1).
-		AddRow("CHKPT", 1142, 1).
-		AddRow("LAZYWRITER_SLEEP", 1118786296, 1126388).
-		AddRow("PREEMPTIVE_OS_DEVICEOPS", 119, 90)
+	perfCounterRows := sqlmock.NewRows([]string{"buffer_cache_hit_ratio", "buffer_pool_hit_percent", "sql_compilations", "sql_recompilations", "user_connections", "lock_wait_time_ms", "page_splits_sec", "checkpoint_pages_sec", "deadlocks_sec", "user_errors", "kill_connection_errors", "batch_request_sec", "page_life_expectancy_ms", "transactions_sec", "forced_parameterizations_sec"})
 
 	// only match the performance counter query
-	mock.ExpectQuery(`SELECT wait_type, wait_time_ms AS wait_time, waiting_tasks_count\s*FROM sys.dm_os_wait_stats wait_stats\s*WHERE wait_time_ms != 0`).WillReturnRows(waitTimeRows)
+	mock.ExpectQuery(`SELECT\s+t1.cntr_value AS buffer_cache_hit_ratio.*`).WillReturnRows(perfCounterRows)
 	mock.ExpectClose()
 
-	populateWaitTimeMetrics(e, conn)
+	args := args.ArgumentList{
+		EnableBufferMetrics: true,
+	}
+	PopulateInstanceMetrics(e, conn, args, nil)
 
 	actual, _ := i.MarshalJSON()
-	expectedFile := filepath.Join("..", "testdata", "waitTime.json.golden")
+	expectedFile := filepath.Join("..", "testdata", "empty.json.golden")
 	assert.NoError(t, updateGoldenFile(actual, expectedFile))
 
 	checkAgainstFile(t, actual, expectedFile)
@@ -319,10 +409,398 @@ func Test_populateCustomQuery(t *testing.T) { //nolint: funlen
 			conn, mock := connection.CreateMockSQL(t)
 			defer conn.Close()
 			tc.setupMock(mock, tc.cq)
-			populateCustomMetrics(e, conn, tc.cq)
+			populateCustomMetrics(e, conn, nil, tc.cq)
 			actual, _ := i.MarshalJSON()
 			expectedFile := filepath.Join("..", "testdata", tc.expectedFileName)
 			checkAgainstFile(t, actual, expectedFile)
 		})
 	}
 }
+
+func Test_metricsFromCustomQueryRow_QueryText(t *testing.T) {
+	columns := []string{"session_id", "query_text", "SQL_Text", "plan_text", "text"}
+	row := []string{"52", "SELECT * FROM cards WHERE number = '4111'", "EXEC pay @amount = 10", "WHERE id = 7", "Job 'nightly' = 3"}
+
+	obfuscator, err := obfuscation.New(obfuscation.ModeObfuscate)
+	assert.NoError(t, err)
+	metrics, err := metricsFromCustomQueryRow(row, columns, customQuery{QueryTextColumns: []string{"plan_text"}, obfuscator: obfuscator})
+	assert.NoError(t, err)
+	assert.Equal(t, "52", metrics["session_id"].value)
+	assert.Equal(t, "SELECT * FROM cards WHERE number = ?", metrics["query_text"].value)
+	assert.Equal(t, "EXEC pay @amount = ?", metrics["SQL_Text"].value)
+	assert.Equal(t, "WHERE id = ?", metrics["plan_text"].value)
+	assert.Equal(t, "Job 'nightly' = 3", metrics["text"].value)
+
+	obfuscator, err = obfuscation.New(obfuscation.ModeDropText)
+	assert.NoError(t, err)
+	metrics, err = metricsFromCustomQueryRow(row, columns, customQuery{obfuscator: obfuscator})
+	assert.NoError(t, err)
+	assert.NotContains(t, metrics, "query_text")
+	assert.NotContains(t, metrics, "SQL_Text")
+	assert.Equal(t, "WHERE id = 7", metrics["plan_text"].value)
+	assert.Equal(t, "Job 'nightly' = 3", metrics["text"].value)
+}
+
+func Test_populateCustomMetrics_DatabaseEntity(t *testing.T) {
+	i, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	dbSetLookup := make(database.DBMetricSetLookup)
+	for _, dbName := range []string{"master", "tempdb"} {
+		dbEntity, err := i.Entity(dbName, "ms-database")
+		assert.NoError(t, err)
+		dbSetLookup[dbName] = dbEntity.NewMetricSet("MssqlDatabaseSample")
+	}
+
+	query := customQuery{Query: "SELECT db_name, used_pages FROM pages", Prefix: "custom.", Entity: customQueryEntityDatabase, dbSetLookup: dbSetLookup}
+	mock.ExpectQuery("SELECT db_name, used_pages FROM pages").WillReturnRows(
+		sqlmock.NewRows([]string{"db_name", "used_pages"}).
+			AddRow("master", 10).
+			AddRow("tempdb", 20).
+			AddRow("model", 30).
+			AddRow("master", 40))
+	populateCustomMetrics(e, conn, nil, query)
+
+	// the rows are set on the sample of their database, the ones of databases not monitored are skipped
+	// as are the rows of a database after the first one
+	assert.Empty(t, e.Metrics)
+	assert.Equal(t, float64(10), dbSetLookup["master"].Metrics["custom.used_pages"])
+	assert.Equal(t, float64(20), dbSetLookup["tempdb"].Metrics["custom.used_pages"])
+	assert.NotContains(t, dbSetLookup["master"].Metrics, "custom.db_name")
+
+	// without a db_name column the rows are set on the database of the query
+	query = customQuery{Query: "SELECT used_pages FROM pages", Database: "tempdb", Entity: customQueryEntityDatabase, dbSetLookup: dbSetLookup}
+	mock.ExpectQuery("SELECT used_pages FROM pages").WillReturnRows(sqlmock.NewRows([]string{"used_pages"}).AddRow(25))
+	populateCustomMetrics(e, conn, nil, query)
+	assert.Equal(t, float64(25), dbSetLookup["tempdb"].Metrics["used_pages"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_populateCustomMetrics_DatabaseEntityNameClash(t *testing.T) {
+	i, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	dbEntity, err := i.Entity("sales", "ms-database")
+	assert.NoError(t, err)
+	ms := dbEntity.NewMetricSet("MssqlDatabaseSample", attribute.Attribute{Key: "team", Value: "billing"})
+	assert.NoError(t, ms.SetMetric("log.transactionGrowth", 3, metric.GAUGE))
+	dbSetLookup := database.DBMetricSetLookup{"sales": ms}
+
+	query := customQuery{
+		Query:       "SELECT used_pages, log_growth FROM pages",
+		Database:    "sales",
+		Entity:      customQueryEntityDatabase,
+		Attributes:  map[string]string{"team": "billing"},
+		Columns:     map[string]customQueryColumn{"log_growth": {Name: "log.transactionGrowth", Type: "gauge"}},
+		dbSetLookup: dbSetLookup,
+	}
+	mock.ExpectQuery("SELECT used_pages, log_growth FROM pages").WillReturnRows(
+		sqlmock.NewRows([]string{"used_pages", "log_growth"}).AddRow(25, 9))
+	populateCustomMetrics(e, conn, nil, query)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// the metrics already on the sample are kept
+	assert.Equal(t, float64(25), ms.Metrics["used_pages"])
+	assert.Equal(t, float64(3), ms.Metrics["log.transactionGrowth"])
+	assert.Equal(t, "billing", ms.Metrics["team"])
+
+	// as are its attributes, when a query sets another value
+	query = customQuery{Query: "SELECT 1 AS metric_value", Database: "sales", Entity: customQueryEntityDatabase, Attributes: map[string]string{"team": "dba", "service": "orders"}, dbSetLookup: dbSetLookup}
+	mock.ExpectQuery("SELECT 1 AS metric_value").WillReturnRows(sqlmock.NewRows([]string{"metric_value"}).AddRow(1))
+	populateCustomMetrics(e, conn, nil, query)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, "billing", ms.Metrics["team"])
+	assert.Equal(t, "orders", ms.Metrics["service"])
+}
+
+func Test_customQuery_databases(t *testing.T) {
+	testCases := []struct {
+		name          string
+		config        string
+		wantFanOut    bool
+		wantDatabases []string
+	}{
+		{"Single Database", "database: master", false, nil},
+		{"List", "database: [tempdb, master]", true, []string{"master", "tempdb"}},
+		{"All Databases", `database: "*"`, true, []string{"master", "sales_eu", "sales_us"}},
+		{"All Databases And System Ones", `database: ["*", tempdb, master]`, true, []string{"master", "sales_eu", "sales_us", "tempdb"}},
+		{"Patterns", "database_include: sales_*\n    database_exclude: /_us$/", true, []string{"sales_eu"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := filepath.Join(t.TempDir(), "custom-queries.yml")
+			assert.NoError(t, os.WriteFile(config, []byte("queries:\n  - query: SELECT 1 AS metric_value\n    "+tc.config+"\n"), 0600))
+
+			queries, err := parseCustomQueries(args.ArgumentList{CustomMetricsConfig: config})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantFanOut, queries[0].fansOut())
+			if !tc.wantFanOut {
+				assert.Equal(t, "master", queries[0].Database)
+				return
+			}
+			assert.Equal(t, tc.wantDatabases, queries[0].databases([]string{"sales_us", "master", "sales_eu"}))
+		})
+	}
+}
+
+func Test_populateCustomQueryMetrics_MaxConcurrency(t *testing.T) {
+	i, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	dbSetLookup := make(database.DBMetricSetLookup)
+	for _, dbName := range []string{"sales", "stock"} {
+		dbEntity, err := i.Entity(dbName, "ms-database")
+		assert.NoError(t, err)
+		dbSetLookup[dbName] = dbEntity.NewMetricSet("MssqlDatabaseSample")
+	}
+
+	config := filepath.Join(t.TempDir(), "custom-queries.yml")
+	assert.NoError(t, os.WriteFile(config, []byte(`queries:
+  - query: SELECT 1 AS metric_value
+  - query: SELECT 2 AS metric_value
+  - query: SELECT 3 AS metric_value
+    database: "*"
+`), 0600))
+	delay := 40 * time.Millisecond
+	for _, query := range []string{`SELECT 1 AS metric_value`, `SELECT 2 AS metric_value`, `USE \[sales\]; SELECT 3`, `USE \[stock\]; SELECT 3`} {
+		mock.ExpectQuery(query).WillDelayFor(delay).WillReturnRows(sqlmock.NewRows([]string{"metric_value"}).AddRow(1))
+	}
+
+	// the four runs share two workers, the queries and the databases of a query alike
+	start := time.Now()
+	PopulateCustomQueryMetrics(e, conn, args.ArgumentList{CustomMetricsConfig: config, MaxConcurrentDatabaseQueries: 2}, dbSetLookup, nil, nil)
+	assert.GreaterOrEqual(t, time.Since(start), 2*delay)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Len(t, e.Metrics, 4)
+}
+
+func Test_useDatabase(t *testing.T) {
+	assert.Equal(t, "USE [sales]; ", useDatabase("sales"))
+	assert.Equal(t, "USE [sales [eu]]]; ", useDatabase("sales [eu]"))
+}
+
+func Test_populateCustomQueryMetrics_FanOut(t *testing.T) {
+	i, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	dbSetLookup := make(database.DBMetricSetLookup)
+	for _, dbName := range []string{"master", "tempdb", "sales"} {
+		dbEntity, err := i.Entity(dbName, "ms-database")
+		assert.NoError(t, err)
+		dbSetLookup[dbName] = dbEntity.NewMetricSet("MssqlDatabaseSample")
+	}
+
+	config := filepath.Join(t.TempDir(), "custom-queries.yml")
+	assert.NoError(t, os.WriteFile(config, []byte("queries:\n  - query: SELECT size FROM sys.database_files\n    database: \"*\"\n    database_exclude: tempdb\n"), 0600))
+	for _, dbName := range []string{"master", "sales"} {
+		mock.ExpectQuery(`USE \[` + dbName + `\]; SELECT size FROM sys\.database_files`).
+			WillReturnRows(sqlmock.NewRows([]string{"size"}).AddRow(8))
+	}
+
+	PopulateCustomQueryMetrics(e, conn, args.ArgumentList{CustomMetricsConfig: config, MaxConcurrentDatabaseQueries: 2}, dbSetLookup, nil, nil)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// a sample for each database the query ran in
+	databases := make([]interface{}, 0, len(e.Metrics))
+	for _, ms := range e.Metrics {
+		assert.Equal(t, "MssqlCustomQuerySample", ms.Metrics["event_type"])
+		assert.Equal(t, float64(8), ms.Metrics["size"])
+		databases = append(databases, ms.Metrics["database"])
+	}
+	assert.ElementsMatch(t, []interface{}{"master", "sales"}, databases)
+}
+
+func Test_populateCustomQueryMetrics_IntervalFailure(t *testing.T) {
+	_, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	config := filepath.Join(t.TempDir(), "custom-queries.yml")
+	assert.NoError(t, os.WriteFile(config, []byte("queries:\n  - query: SELECT 1 AS metric_value\n    interval: 1h\n"), 0600))
+	arguments := args.ArgumentList{CustomMetricsConfig: config, MaxConcurrentDatabaseQueries: 1}
+	instanceState := state.New(persist.NewInMemoryStore(), time.Time{})
+
+	// a failed run is retried by the next collection, and a successful one is not repeated in the interval
+	mock.ExpectQuery(`SELECT 1 AS metric_value`).WillReturnError(assert.AnError)
+	mock.ExpectQuery(`SELECT 1 AS metric_value`).WillReturnRows(sqlmock.NewRows([]string{"metric_value"}).AddRow(1))
+	for run := 0; run < 3; run++ {
+		PopulateCustomQueryMetrics(e, conn, arguments, nil, instanceState, nil)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Len(t, e.Metrics, 1)
+}
+
+func Test_populateCustomMetrics_EventTypeAndAttributes(t *testing.T) {
+	_, e := createTestEntity(t)
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	config := filepath.Join(t.TempDir(), "custom-queries.yml")
+	assert.NoError(t, os.WriteFile(config, []byte(`queries:
+  - query: SELECT backup_count FROM backups
+    event_type: BackupSample
+    attributes:
+      team: dba
+      environment: production
+`), 0600))
+	queries, err := parseCustomQueries(args.ArgumentList{CustomMetricsConfig: config})
+	assert.NoError(t, err)
+
+	mock.ExpectQuery("SELECT backup_count FROM backups").WillReturnRows(sqlmock.NewRows([]string{"backup_count"}).AddRow(3))
+	populateCustomMetrics(e, conn, nil, queries[0])
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Len(t, e.Metrics, 1)
+	sample := e.Metrics[0].Metrics
+	assert.Equal(t, "BackupSample", sample["event_type"])
+	assert.Equal(t, "dba", sample["team"])
+	assert.Equal(t, "production", sample["environment"])
+	assert.Equal(t, float64(3), sample["backup_count"])
+}
+
+func Test_populateCustomMetrics_MultipleRowDeltas(t *testing.T) {
+	i, err := integration.New("test", "1.0.0", integration.InMemoryStore())
+	assert.NoError(t, err)
+	e, err := i.Entity("test", "instance")
+	assert.NoError(t, err)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	config := filepath.Join(t.TempDir(), "custom-queries.yml")
+	assert.NoError(t, os.WriteFile(config, []byte(`queries:
+  - query: SELECT file_name, reads, writes FROM file_stats
+    event_type: FileStatsSample
+    columns:
+      file_name: {type: attribute, name: fileName}
+      reads: {type: delta}
+      writes: {type: rate}
+`), 0600))
+	queries, err := parseCustomQueries(args.ArgumentList{CustomMetricsConfig: config})
+	assert.NoError(t, err)
+
+	columns := []string{"file_name", "reads", "writes"}
+	mock.ExpectQuery("SELECT file_name, reads, writes FROM file_stats").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("data", 100, 600).AddRow("log", 1000, 1200))
+	mock.ExpectQuery("SELECT file_name, reads, writes FROM file_stats").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("data", 130, 1200).AddRow("log", 1500, 7200))
+
+	// the previous collection was a minute ago
+	persist.SetNow(func() time.Time { return time.Now().Add(-time.Minute) })
+	populateCustomMetrics(e, conn, nil, queries[0])
+	persist.SetNow(time.Now)
+	populateCustomMetrics(e, conn, nil, queries[0])
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// the deltas of each row are computed from the previous value of the same row
+	assert.Len(t, e.Metrics, 4)
+	dataFile, logFile := e.Metrics[2].Metrics, e.Metrics[3].Metrics
+	assert.Equal(t, "data", dataFile["fileName"])
+	assert.Equal(t, float64(30), dataFile["reads"])
+	assert.InDelta(t, 10, dataFile["writes"], 0.5)
+	assert.Equal(t, "log", logFile["fileName"])
+	assert.Equal(t, float64(500), logFile["reads"])
+	assert.InDelta(t, 100, logFile["writes"], 2)
+}
+
+func Test_metricsFromCustomQueryRow_Columns(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "custom-queries.yml")
+	assert.NoError(t, os.WriteFile(config, []byte(`queries:
+  - query: SELECT error_number, zip_code, size, reads, debug, state, latency, other FROM errors
+    prefix: err_
+    columns:
+      error_number: {type: attribute}
+      ZIP_CODE: {type: attribute, name: zipCode}
+      size: {type: gauge, name: fileSize, unit: bytes}
+      reads: {type: cumulative_counter}
+      debug: {type: ignore}
+      state: {name: errorState}
+      latency: {unit: milliseconds}
+`), 0600))
+	queries, err := parseCustomQueries(args.ArgumentList{CustomMetricsConfig: config})
+	assert.NoError(t, err)
+
+	// the type of the columns declared without it is detected from their values
+	columns := []string{"error_number", "zip_code", "size", "reads", "debug", "state", "latency", "other"}
+	metrics, err := metricsFromCustomQueryRow([]string{"1205", "02134", "8192", "42", "x", "ONLINE", "12", "7"}, columns, queries[0])
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]customQueryMetricValue{
+		"err_error_number":          {value: "1205", sourceType: metric.ATTRIBUTE},
+		"err_zipCode":               {value: "02134", sourceType: metric.ATTRIBUTE},
+		"err_fileSizeInBytes":       {value: "8192", sourceType: metric.GAUGE},
+		"err_reads":                 {value: "42", sourceType: metric.PDELTA},
+		"err_errorState":            {value: "ONLINE", sourceType: metric.ATTRIBUTE},
+		"err_latencyInMilliseconds": {value: "12", sourceType: metric.GAUGE},
+		"err_other":                 {value: "7", sourceType: metric.GAUGE},
+	}, metrics)
+}
+
+func Test_customQuery_due(t *testing.T) {
+	instanceState := state.New(persist.NewInMemoryStore(), time.Time{})
+	query := customQuery{Query: "SELECT 1 AS metric_value", Interval: 10 * time.Minute, Offset: 2 * time.Minute}
+	start := time.Date(2026, 10, 18, 9, 5, 0, 0, time.UTC)
+
+	// the first collection runs it, then once in each interval starting at 2, 12, 22... minutes past the hour
+	assert.True(t, query.due(instanceState, start))
+	query.recordRun(instanceState, start)
+	assert.False(t, query.due(instanceState, start.Add(15*time.Second)))
+	assert.False(t, query.due(instanceState, start.Add(6*time.Minute+59*time.Second)))
+	assert.True(t, query.due(instanceState, start.Add(7*time.Minute)))
+	// until its run is recorded
+	assert.True(t, query.due(instanceState, start.Add(8*time.Minute)))
+	query.recordRun(instanceState, start.Add(8*time.Minute))
+	assert.False(t, query.due(instanceState, start.Add(16*time.Minute)))
+
+	// queries without interval or state run on every collection
+	every := customQuery{Query: "SELECT 2 AS metric_value"}
+	assert.True(t, every.due(instanceState, start))
+	assert.True(t, every.due(instanceState, start))
+	assert.True(t, query.due(nil, start))
+}
+
+func Test_parseCustomQueries(t *testing.T) {
+	testCases := []struct {
+		name      string
+		config    string
+		wantError bool
+	}{
+		{"Interval", "queries:\n  - query: SELECT 1 AS metric_value\n    interval: 5m\n    offset: 1m\n", false},
+		{"Interval Without Unit", "queries:\n  - query: SELECT 1 AS metric_value\n    interval: 300\n", true},
+		{"Offset Without Interval", "queries:\n  - query: SELECT 1 AS metric_value\n    offset: 1m\n", true},
+		{"Interval Longer Than State TTL", "queries:\n  - query: SELECT 1 AS metric_value\n    interval: 48h\n", true},
+		{"Offset Longer Than Interval", "queries:\n  - query: SELECT 1 AS metric_value\n    interval: 5m\n    offset: 5m\n", true},
+		{"Invalid Database Pattern", "queries:\n  - query: SELECT 1 AS metric_value\n    interval: 5m\n    database_include: /sales_[/\n", true},
+		{"Invalid Event Type", "queries:\n  - query: SELECT 1 AS metric_value\n    interval: 5m\n    event_type: Mssql Backup Sample\n", true},
+		{"Event Type Of Database Entity", "queries:\n  - query: SELECT 1 AS metric_value\n    interval: 5m\n    entity: database\n    event_type: BackupSample\n", true},
+		{"Reserved Event Type", "queries:\n  - query: SELECT 1 AS metric_value\n    interval: 5m\n    event_type: MssqlDatabaseSample\n", true},
+		{"Reserved Event Type Case", "queries:\n  - query: SELECT 1 AS metric_value\n    interval: 5m\n    event_type: MSSQLBackupSample\n", true},
+		{"Reserved Attribute", "queries:\n  - query: SELECT 1 AS metric_value\n    interval: 5m\n    attributes:\n      host: db01\n", true},
+		{"Unknown Column Type", "queries:\n  - query: SELECT 1 AS metric_value\n    interval: 5m\n    columns:\n      zip_code: {type: string}\n", true},
+		{"Unit Of Attribute Column", "queries:\n  - query: SELECT 1 AS metric_value\n    interval: 5m\n    columns:\n      zip_code: {type: attribute, unit: bytes}\n", true},
+		{"Metric Value Column", "queries:\n  - query: SELECT 1 AS metric_value\n    interval: 5m\n    columns:\n      metric_value: {type: gauge}\n", true},
+		{"Unknown Entity", "queries:\n  - query: SELECT 1 AS metric_value\n    interval: 5m\n    entity: host\n", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := filepath.Join(t.TempDir(), "custom-queries.yml")
+			assert.NoError(t, os.WriteFile(config, []byte(tc.config), 0600))
+
+			queries, err := parseCustomQueries(args.ArgumentList{CustomMetricsConfig: config})
+			if tc.wantError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, 5*time.Minute, queries[0].Interval)
+			assert.Equal(t, time.Minute, queries[0].Offset)
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/obfuscation"
+	"github.com/newrelic/nri-mssql/src/state"
+)
+
+const (
+	// queryStatsSnapshotKey stores the statistics of the previous collection
+	queryStatsSnapshotKey = "queryStats.snapshot"
+	// queryStatsFirstWindow is the time window of the queries used as baseline in the first collection
+	queryStatsFirstWindow = 10 * time.Minute
+	// queryStatsWindowMargin is added to the time since the previous collection, so no execution is missed
+	queryStatsWindowMargin = time.Minute
+	// queryStatsRetention is how long the statistics of a query not executed anymore are kept
+	queryStatsRetention = time.Hour
+	// maxQueryTextLength is the maximum length of the query text reported, below the limit of attributes
+	maxQueryTextLength = 4000
+)
+
+// queryStatsState is the state kept between collections
+type queryStatsState struct {
+	// CollectedAt is when the statistics were collected, as Unix time in nanoseconds
+	CollectedAt int64
+	Queries     map[string]queryStatsSnapshot
+}
+
+// queryStatsSnapshot are the cumulative statistics of a query in the previous collection
+type queryStatsSnapshot struct {
+	Executions    int64
+	WorkerTime    int64
+	ElapsedTime   int64
+	LogicalReads  int64
+	PhysicalReads int64
+	LogicalWrites int64
+	// PlanCreated is when the plan was cached and LastSeen when the query was last collected, as Unix times
+	PlanCreated int64
+	LastSeen    int64
+}
+
+// queryStatsDelta are the statistics of a query in the interval between collections
+type queryStatsDelta struct {
+	stats         *queryStatsModel
+	executions    int64
+	workerTime    int64
+	elapsedTime   int64
+	logicalReads  int64
+	physicalReads int64
+	logicalWrites int64
+	rankedBy      []string
+}
+
+// queryStatsRankings are the statistics the top queries are selected by
+var queryStatsRankings = []struct {
+	name  string
+	value func(d *queryStatsDelta) int64
+}{
+	{"cpu", func(d *queryStatsDelta) int64 { return d.workerTime }},
+	{"duration", func(d *queryStatsDelta) int64 { return d.elapsedTime }},
+	{"reads", func(d *queryStatsDelta) int64 { return d.logicalReads }},
+	{"executions", func(d *queryStatsDelta) int64 { return d.executions }},
+}
+
+// PopulateQueryMetrics reports an MssqlQuerySample for the top queries by CPU, duration, reads and executions
+// since the previous collection. The cumulative statistics of the plan cache are kept in the state of the instance to compute
+// the ones of the interval, so the first collection only records the baseline.
+func PopulateQueryMetrics(instanceEntity *integration.Entity, con *connection.SQLConnection, arguments args.ArgumentList, instanceState *state.State, telemetry *Telemetry) {
+	if !arguments.EnableQueryMetrics {
+		telemetry.recordDisabled(queryStatsDefinition)
+		return
+	}
+
+	if instanceState == nil {
+		telemetry.recordSkipped(queryStatsDefinition)
+		return
+	}
+
+	if err := con.Err(); err != nil {
+		log.Warn("Skipping query performance queries: %s", err.Error())
+		telemetry.recordSkipped(queryStatsDefinition)
+		return
+	}
+
+	queryDef, ok := queryStatsDefinition.forServer(con.ServerInfo)
+	if !ok {
+		telemetry.recordUnsupported(queryStatsDefinition)
+		return
+	}
+
+	var previous queryStatsState
+	hasBaseline := instanceState.Counters(queryStatsSnapshotKey, &previous)
+	hasBaseline = hasBaseline && previous.CollectedAt > 0
+	snapshots := previous.Queries
+	if !hasBaseline || snapshots == nil {
+		snapshots = make(map[string]queryStatsSnapshot)
+	}
+
+	now := time.Now()
+	previousCollection := time.Unix(0, previous.CollectedAt)
+	interval := now.Sub(previousCollection)
+	window := queryStatsFirstWindow
+	if hasBaseline {
+		window = interval + queryStatsWindowMargin
+	}
+
+	stats := make([]queryStatsModel, 0)
+	query := queryDef.GetQuery(queryStatsReplace(int64(window.Seconds()), queryStatsLimit))
+	if err := runQueryDefinition(con, telemetry, queryDef, query, &stats); err != nil {
+		log.Error("Could not execute query stats query: %s", err.Error())
+		return
+	}
+
+	deltas := make([]*queryStatsDelta, 0, len(stats))
+	for index := range stats {
+		if delta := updateQueryStats(snapshots, &stats[index], now, previousCollection, hasBaseline); delta != nil {
+			deltas = append(deltas, delta)
+		}
+	}
+
+	// forget the queries not executed for a while
+	for key, snapshot := range snapshots {
+		if now.Sub(time.Unix(snapshot.LastSeen, 0)) > queryStatsRetention {
+			delete(snapshots, key)
+		}
+	}
+	instanceState.SetCounters(queryStatsSnapshotKey, queryStatsState{CollectedAt: now.UnixNano(), Queries: snapshots})
+
+	obfuscator, _ := obfuscation.New(arguments.QueryTextMode)
+	for _, delta := range topQueries(deltas, arguments.QueryMetricsTopN) {
+		populateQuerySample(instanceEntity, con.Host, obfuscator, delta, interval)
+	}
+}
+
+// updateQueryStats stores the statistics of a query in snapshots and returns the ones of the interval since the
+// previous collection. It returns nil when the query was not executed or its previous statistics are unknown.
+// The statistics of a query missing from any collection since it was last seen don't cover only the interval,
+// so they are not used as baseline.
+func updateQueryStats(snapshots map[string]queryStatsSnapshot, stats *queryStatsModel, now, previousCollection time.Time, hasBaseline bool) *queryStatsDelta {
+	key := stats.QueryHash + ":" + stats.PlanHash
+	current := queryStatsSnapshot{
+		Executions:    stats.Executions,
+		WorkerTime:    stats.WorkerTime,
+		ElapsedTime:   stats.ElapsedTime,
+		LogicalReads:  stats.LogicalReads,
+		PhysicalReads: stats.PhysicalReads,
+		LogicalWrites: stats.LogicalWrites,
+		PlanCreated:   now.Unix() - stats.PlanAgeSeconds,
+		LastSeen:      now.Unix(),
+	}
+	previous, ok := snapshots[key]
+	ok = ok && previous.LastSeen == previousCollection.Unix()
+	snapshots[key] = current
+
+	if !hasBaseline {
+		return nil
+	}
+
+	delta := &queryStatsDelta{stats: stats}
+	switch {
+	// the statistics restart when the plan is evicted from the cache or the instance restarts
+	case ok && current.PlanCreated <= previous.PlanCreated+1 && current.Executions >= previous.Executions:
+		delta.executions = current.Executions - previous.Executions
+		delta.workerTime = current.WorkerTime - previous.WorkerTime
+		delta.elapsedTime = current.ElapsedTime - previous.ElapsedTime
+		delta.logicalReads = current.LogicalReads - previous.LogicalReads
+		delta.physicalReads = current.PhysicalReads - previous.PhysicalReads
+		delta.logicalWrites = current.LogicalWrites - previous.LogicalWrites
+	case time.Duration(stats.PlanAgeSeconds)*time.Second <= now.Sub(previousCollection):
+		// cached since the previous collection, so every execution is in the interval
+		delta.executions = current.Executions
+		delta.workerTime = current.WorkerTime
+		delta.elapsedTime = current.ElapsedTime
+		delta.logicalReads = current.LogicalReads
+		delta.physicalReads = current.PhysicalReads
+		delta.logicalWrites = current.LogicalWrites
+	default:
+		return nil
+	}
+
+	if delta.executions <= 0 {
+		return nil
+	}
+	return delta
+}
+
+// topQueries returns the topN queries of each ranking, ordered by CPU time
+func topQueries(deltas []*queryStatsDelta, topN int) []*queryStatsDelta {
+	if topN < 1 {
+		return nil
+	}
+
+	selected := make(map[*queryStatsDelta]bool)
+	ranked := make([]*queryStatsDelta, len(deltas))
+	for _, ranking := range queryStatsRankings {
+		copy(ranked, deltas)
+		sort.SliceStable(ranked, func(a, b int) bool {
+			return ranking.value(ranked[a]) > ranking.value(ranked[b])
+		})
+		for index := 0; index < topN && index < len(ranked); index++ {
+			ranked[index].rankedBy = append(ranked[index].rankedBy, ranking.name)
+			selected[ranked[index]] = true
+		}
+	}
+
+	top := make([]*queryStatsDelta, 0, len(selected))
+	for _, delta := range deltas {
+		if selected[delta] {
+			top = append(top, delta)
+		}
+	}
+	sort.SliceStable(top, func(a, b int) bool {
+		return top[a].workerTime > top[b].workerTime
+	})
+
+	return top
+}
+
+func populateQuerySample(instanceEntity *integration.Entity, host string, obfuscator *obfuscation.Obfuscator, delta *queryStatsDelta, interval time.Duration) {
+	attributes := []attribute.Attribute{
+		{Key: "displayName", Value: instanceEntity.Metadata.Name},
+		{Key: "entityName", Value: instanceEntity.Metadata.Namespace + ":" + instanceEntity.Metadata.Name},
+		{Key: "host", Value: host},
+		{Key: "instance", Value: instanceEntity.Metadata.Name},
+		{Key: "queryHash", Value: delta.stats.QueryHash},
+		{Key: "planHash", Value: delta.stats.PlanHash},
+		{Key: "rankedBy", Value: strings.Join(delta.rankedBy, ",")},
+	}
+	if delta.stats.DatabaseName != nil {
+		attributes = append(attributes, attribute.Attribute{Key: "databaseName", Value: *delta.stats.DatabaseName})
+	}
+	if delta.stats.QueryText != nil {
+		if text, ok := obfuscator.Apply(*delta.stats.QueryText); ok {
+			attributes = append(attributes, attribute.Attribute{Key: "queryText", Value: truncateQueryText(text)})
+		}
+	}
+	metricSet := instanceEntity.NewMetricSet("MssqlQuerySample", attributes...)
+
+	executions := float64(delta.executions)
+	metrics := []struct {
+		metricName  string
+		metricValue float64
+	}{
+		{"query.executions", executions},
+		{"query.cpuTimeInMilliseconds", float64(delta.workerTime) / 1000},
+		{"query.avgCpuTimeInMilliseconds", float64(delta.workerTime) / 1000 / executions},
+		{"query.durationInMilliseconds", float64(delta.elapsedTime) / 1000},
+		{"query.avgDurationInMilliseconds", float64(delta.elapsedTime) / 1000 / executions},
+		{"query.logicalReads", float64(delta.logicalReads)},
+		{"query.avgLogicalReads", float64(delta.logicalReads) / executions},
+		{"query.physicalReads", float64(delta.physicalReads)},
+		{"query.logicalWrites", float64(delta.logicalWrites)},
+		{"query.intervalInSeconds", interval.Seconds()},
+	}
+	for _, m := range metrics {
+		if err := metricSet.SetMetric(m.metricName, m.metricValue, metric.GAUGE); err != nil {
+			log.Error("Could not set query metric '%s' for query '%s': %s", m.metricName, delta.stats.QueryHash, err.Error())
+		}
+	}
+}
+
+// truncateQueryText limits the length of the query text reported
+func truncateQueryText(text string) string {
+	if len(text) <= maxQueryTextLength {
+		return text
+	}
+	end := maxQueryTextLength
+	for end > 0 && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	return text[:end]
+}
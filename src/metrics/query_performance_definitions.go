@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Placeholders of the query stats query
+const (
+	queryStatsWindowPlaceHolder = "%WINDOW_SECONDS%"
+	queryStatsLimitPlaceHolder  = "%LIMIT%"
+)
+
+// queryStatsLimit is the maximum number of queries in the plan cache compared between runs
+const queryStatsLimit = 1000
+
+// queryStatsModel is the cumulative statistics of a query plan in the plan cache
+type queryStatsModel struct {
+	QueryHash      string  `db:"query_hash"`
+	PlanHash       string  `db:"plan_hash"`
+	Executions     int64   `db:"execution_count"`
+	WorkerTime     int64   `db:"total_worker_time"`
+	ElapsedTime    int64   `db:"total_elapsed_time"`
+	LogicalReads   int64   `db:"total_logical_reads"`
+	PhysicalReads  int64   `db:"total_physical_reads"`
+	LogicalWrites  int64   `db:"total_logical_writes"`
+	PlanAgeSeconds int64   `db:"plan_age_seconds"`
+	DatabaseName   *string `db:"database_name"`
+	QueryText      *string `db:"query_text"`
+}
+
+// queryStatsDefinition gets the statistics of the queries executed in the last WINDOW_SECONDS, adding up
+// the cached plans with the same query and plan hash. The text and database are the ones of the plan
+// executed last. Times are in microseconds. The queries executed last are taken, rather than the ones
+// with the most lifetime totals, as the top queries are ranked by their statistics in the interval.
+var queryStatsDefinition = &QueryDefinition{
+	name: "query_stats",
+	query: `SELECT TOP (%LIMIT%)
+		CONVERT(varchar(18), s.query_hash, 1) AS query_hash,
+		CONVERT(varchar(18), s.query_plan_hash, 1) AS plan_hash,
+		s.execution_count,
+		s.total_worker_time,
+		s.total_elapsed_time,
+		s.total_logical_reads,
+		s.total_physical_reads,
+		s.total_logical_writes,
+		DATEDIFF(SECOND, s.creation_time, GETDATE()) AS plan_age_seconds,
+		DB_NAME(CAST(pa.value AS int)) AS database_name,
+		SUBSTRING(t.text, s.statement_start_offset / 2 + 1,
+			(CASE s.statement_end_offset WHEN -1 THEN DATALENGTH(t.text) ELSE s.statement_end_offset END - s.statement_start_offset) / 2 + 1) AS query_text
+		FROM (
+			SELECT
+			query_hash, query_plan_hash, sql_handle, plan_handle, statement_start_offset, statement_end_offset,
+			ROW_NUMBER() OVER (PARTITION BY query_hash, query_plan_hash ORDER BY last_execution_time DESC) AS plan_number,
+			SUM(execution_count) OVER (PARTITION BY query_hash, query_plan_hash) AS execution_count,
+			SUM(total_worker_time) OVER (PARTITION BY query_hash, query_plan_hash) AS total_worker_time,
+			SUM(total_elapsed_time) OVER (PARTITION BY query_hash, query_plan_hash) AS total_elapsed_time,
+			SUM(total_logical_reads) OVER (PARTITION BY query_hash, query_plan_hash) AS total_logical_reads,
+			SUM(total_physical_reads) OVER (PARTITION BY query_hash, query_plan_hash) AS total_physical_reads,
+			SUM(total_logical_writes) OVER (PARTITION BY query_hash, query_plan_hash) AS total_logical_writes,
+			MIN(creation_time) OVER (PARTITION BY query_hash, query_plan_hash) AS creation_time,
+			MAX(last_execution_time) OVER (PARTITION BY query_hash, query_plan_hash) AS last_execution_time
+			FROM sys.dm_exec_query_stats WITH (NOLOCK)
+		) s
+		CROSS APPLY sys.dm_exec_sql_text(s.sql_handle) t
+		OUTER APPLY (SELECT value FROM sys.dm_exec_plan_attributes(s.plan_handle) WHERE attribute = 'dbid') pa
+		WHERE s.plan_number = 1 AND s.last_execution_time >= DATEADD(SECOND, -%WINDOW_SECONDS%, GETDATE())
+		ORDER BY s.last_execution_time DESC`,
+	dataModels: &[]queryStatsModel{},
+}
+
+// queryStatsReplace sets the time window and maximum number of queries of the query stats query
+func queryStatsReplace(windowSeconds int64, limit int) QueryModifier {
+	return func(query string) string {
+		query = strings.Replace(query, queryStatsWindowPlaceHolder, strconv.FormatInt(windowSeconds, 10), -1)
+		return strings.Replace(query, queryStatsLimitPlaceHolder, strconv.Itoa(limit), -1)
+	}
+}
@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/persist"
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/state"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var queryStatsColumns = []string{"query_hash", "plan_hash", "execution_count", "total_worker_time", "total_elapsed_time", "total_logical_reads", "total_physical_reads", "total_logical_writes", "plan_age_seconds", "database_name", "query_text"}
+
+func Test_populateQueryMetrics_FirstCollection(t *testing.T) {
+	_, e := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	mock.ExpectQuery(`SELECT TOP \(1000\).*DATEADD\(SECOND, -600, GETDATE\(\)\)\s+ORDER BY s\.last_execution_time DESC`).
+		WillReturnRows(sqlmock.NewRows(queryStatsColumns).
+			AddRow("0x01", "0x0A", 10, 5000, 6000, 100, 0, 0, 3600, "sales", "SELECT 1"))
+
+	store := persist.NewInMemoryStore()
+	PopulateQueryMetrics(e, conn, args.ArgumentList{EnableQueryMetrics: true, QueryMetricsTopN: 10}, state.New(store, time.Time{}), nil)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// the first collection only records the baseline
+	assert.Empty(t, e.Metrics)
+	var stored queryStatsState
+	_, err := store.Get(queryStatsSnapshotKey, &stored)
+	assert.NoError(t, err)
+	assert.NotZero(t, stored.CollectedAt)
+	assert.Equal(t, int64(10), stored.Queries["0x01:0x0A"].Executions)
+}
+
+func Test_populateQueryMetrics(t *testing.T) {
+	_, e := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	collectedAt := time.Now()
+	now := collectedAt.Unix()
+	store := persist.NewInMemoryStore()
+	store.Set(queryStatsSnapshotKey, queryStatsState{CollectedAt: collectedAt.UnixNano(), Queries: map[string]queryStatsSnapshot{
+		"0x01:0x0A": {Executions: 10, WorkerTime: 5000, ElapsedTime: 6000, LogicalReads: 100, PlanCreated: now - 3600, LastSeen: now},
+		"0x03:0x0C": {Executions: 5, WorkerTime: 1000, ElapsedTime: 1000, LogicalReads: 10, PlanCreated: now - 3600, LastSeen: now},
+		"0x09:0x0F": {Executions: 1, PlanCreated: now - 7200, LastSeen: now - 7200},
+	}})
+
+	mock.ExpectQuery(`FROM sys\.dm_exec_query_stats`).
+		WillReturnRows(sqlmock.NewRows(queryStatsColumns).
+			// executed 20 times since the previous collection
+			AddRow("0x01", "0x0A", 30, 45000, 66000, 300, 20, 4, 3600, "sales", "SELECT * FROM orders WHERE id = 1").
+			// cached since the previous collection
+			AddRow("0x02", "0x0B", 2, 2000, 90000, 5000, 0, 0, 0, nil, "SELECT * FROM customers").
+			// not executed since the previous collection
+			AddRow("0x03", "0x0C", 5, 1000, 1000, 10, 0, 0, 3600, "sales", "SELECT 1").
+			// cached before the previous collection and unknown
+			AddRow("0x04", "0x0D", 100, 100000, 100000, 100, 0, 0, 3600, "sales", "SELECT 2"))
+
+	PopulateQueryMetrics(e, conn, args.ArgumentList{EnableQueryMetrics: true, QueryMetricsTopN: 1}, state.New(store, time.Time{}), nil)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Len(t, e.Metrics, 2)
+	orders := e.Metrics[0].Metrics
+	assert.Equal(t, "MssqlQuerySample", orders["event_type"])
+	assert.Equal(t, "0x01", orders["queryHash"])
+	assert.Equal(t, "sales", orders["databaseName"])
+	assert.Equal(t, "SELECT * FROM orders WHERE id = ?", orders["queryText"])
+	assert.Equal(t, "cpu,executions", orders["rankedBy"])
+	assert.Equal(t, float64(20), orders["query.executions"])
+	assert.Equal(t, float64(40), orders["query.cpuTimeInMilliseconds"])
+	assert.Equal(t, float64(2), orders["query.avgCpuTimeInMilliseconds"])
+	assert.Equal(t, float64(200), orders["query.logicalReads"])
+
+	customers := e.Metrics[1].Metrics
+	assert.Equal(t, "0x02", customers["queryHash"])
+	assert.Equal(t, "duration,reads", customers["rankedBy"])
+	assert.Equal(t, float64(45), customers["query.avgDurationInMilliseconds"])
+	assert.NotContains(t, customers, "databaseName")
+
+	// the queries not executed for a while are forgotten
+	var stored queryStatsState
+	_, err := store.Get(queryStatsSnapshotKey, &stored)
+	assert.NoError(t, err)
+	assert.Len(t, stored.Queries, 4)
+	assert.NotContains(t, stored.Queries, "0x09:0x0F")
+}
+
+func Test_updateQueryStats_PlanRecompiled(t *testing.T) {
+	now := time.Now()
+	previousCollection := now.Add(-time.Minute)
+	snapshots := map[string]queryStatsSnapshot{
+		"0x01:0x0A": {Executions: 50, WorkerTime: 5000, PlanCreated: now.Unix() - 3600, LastSeen: previousCollection.Unix()},
+	}
+
+	// the plan was evicted and cached again since the previous collection
+	stats := &queryStatsModel{QueryHash: "0x01", PlanHash: "0x0A", Executions: 3, WorkerTime: 300, PlanAgeSeconds: 20}
+	delta := updateQueryStats(snapshots, stats, now, previousCollection, true)
+	assert.Equal(t, int64(3), delta.executions)
+	assert.Equal(t, int64(300), delta.workerTime)
+
+	// evicted and cached again before the previous collection, the executions in the interval are unknown
+	stats = &queryStatsModel{QueryHash: "0x01", PlanHash: "0x0A", Executions: 1, WorkerTime: 300, PlanAgeSeconds: 120}
+	snapshots["0x01:0x0A"] = queryStatsSnapshot{Executions: 50, PlanCreated: now.Unix() - 3600, LastSeen: previousCollection.Unix()}
+	assert.Nil(t, updateQueryStats(snapshots, stats, now, previousCollection, true))
+}
+
+func Test_updateQueryStats_MissingFromCollection(t *testing.T) {
+	first := time.Now().Add(-3 * time.Minute)
+	second := first.Add(time.Minute)
+	third := second.Add(time.Minute)
+	snapshots := make(map[string]queryStatsSnapshot)
+
+	stats := &queryStatsModel{QueryHash: "0x01", PlanHash: "0x0A", Executions: 10, WorkerTime: 1000, PlanAgeSeconds: 3600}
+	assert.Nil(t, updateQueryStats(snapshots, stats, first, time.Time{}, false))
+
+	// the query is not collected in the second collection, Ex: it is not in the top queries, and comes back in
+	// the third one, so its executions since the first collection are not attributed to the last interval
+	stats = &queryStatsModel{QueryHash: "0x01", PlanHash: "0x0A", Executions: 40, WorkerTime: 4000, PlanAgeSeconds: 3720}
+	assert.Nil(t, updateQueryStats(snapshots, stats, third, second, true))
+	assert.Equal(t, third.Unix(), snapshots["0x01:0x0A"].LastSeen)
+
+	// collected in consecutive collections again
+	fourth := third.Add(time.Minute)
+	stats = &queryStatsModel{QueryHash: "0x01", PlanHash: "0x0A", Executions: 45, WorkerTime: 4500, PlanAgeSeconds: 3780}
+	delta := updateQueryStats(snapshots, stats, fourth, third, true)
+	assert.Equal(t, int64(5), delta.executions)
+	assert.Equal(t, int64(500), delta.workerTime)
+}
+
+func Test_truncateQueryText(t *testing.T) {
+	assert.Equal(t, "SELECT 1", truncateQueryText("SELECT 1"))
+
+	text := strings.Repeat("a", maxQueryTextLength-1) + "é"
+	truncated := truncateQueryText(text)
+	assert.Len(t, truncated, maxQueryTextLength-1)
+}
@@ -0,0 +1,245 @@
+package metrics
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+)
+
+// Status of a query definition in a collection run
+const (
+	queryStatusOK       = "ok"
+	queryStatusError    = "error"
+	queryStatusDisabled = "disabled"
+	queryStatusSkipped  = "skipped"
+	// queryStatusUnsupported is reported for definitions not supported by the server version or edition
+	queryStatusUnsupported = "unsupported"
+)
+
+// Classes of the errors returned by queries
+const (
+	errorClassTimeout       = "timeout"
+	errorClassCancelled     = "cancelled"
+	errorClassConnection    = "connection"
+	errorClassPermission    = "permission"
+	errorClassInvalidObject = "invalid_object"
+	errorClassQuery         = "query"
+)
+
+// SQL Server error numbers used to classify query errors
+var (
+	permissionErrorNumbers    = map[int32]bool{229: true, 230: true, 262: true, 297: true, 300: true, 916: true}
+	invalidObjectErrorNumbers = map[int32]bool{207: true, 208: true, 2812: true, 4121: true}
+)
+
+// Telemetry records how every query definition performed during a collection run, so a collector
+// that stops working can be detected. It is safe for concurrent use and a nil Telemetry records nothing.
+type Telemetry struct {
+	lock    sync.Mutex
+	start   time.Time
+	queries map[string]*queryTelemetry
+}
+
+type queryTelemetry struct {
+	status     string
+	executions int
+	errors     int
+	rows       int
+	duration   time.Duration
+	errorClass string
+}
+
+// NewTelemetry starts recording the telemetry of a collection run
+func NewTelemetry() *Telemetry {
+	return &Telemetry{
+		start:   time.Now(),
+		queries: make(map[string]*queryTelemetry),
+	}
+}
+
+// recordQuery records an execution of the query definition name. Definitions run more than once
+// in a run, such as the ones specific to each database, are aggregated.
+func (t *Telemetry) recordQuery(name string, duration time.Duration, rows int, err error) {
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	q := t.query(name)
+	q.executions++
+	q.duration += duration
+	q.rows += rows
+	if err != nil {
+		q.errors++
+		q.status = queryStatusError
+		q.errorClass = classifyError(err)
+	} else if q.status != queryStatusError {
+		q.status = queryStatusOK
+	}
+}
+
+// recordDisabled records query definitions not run because they are disabled by the configuration
+func (t *Telemetry) recordDisabled(definitions ...*QueryDefinition) {
+	t.recordStatus(queryStatusDisabled, definitions...)
+}
+
+// recordSkipped records query definitions not run in this collection, Ex: the collection timeout was reached
+func (t *Telemetry) recordSkipped(definitions ...*QueryDefinition) {
+	t.recordStatus(queryStatusSkipped, definitions...)
+}
+
+// recordUnsupported records query definitions not run because the server does not support them
+func (t *Telemetry) recordUnsupported(definitions ...*QueryDefinition) {
+	t.recordStatus(queryStatusUnsupported, definitions...)
+}
+
+func (t *Telemetry) recordStatus(status string, definitions ...*QueryDefinition) {
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, definition := range definitions {
+		q := t.query(definition.GetName())
+		if q.executions == 0 {
+			q.status = status
+		}
+	}
+}
+
+func (t *Telemetry) query(name string) *queryTelemetry {
+	q, ok := t.queries[name]
+	if !ok {
+		q = &queryTelemetry{}
+		t.queries[name] = q
+	}
+	return q
+}
+
+// Populate reports an MssqlIntegrationSample on the instance entity for every query definition,
+// plus one with the totals of the run
+func (t *Telemetry) Populate(instanceEntity *integration.Entity, host string) {
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	names := make([]string, 0, len(t.queries))
+	for name := range t.queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	statusCount := map[string]int{}
+	var executions, errorCount int
+	for _, name := range names {
+		q := t.queries[name]
+		statusCount[q.status]++
+		executions += q.executions
+		errorCount += q.errors
+
+		attributes := []attribute.Attribute{
+			{Key: "queryName", Value: name},
+			{Key: "status", Value: q.status},
+		}
+		if q.errorClass != "" {
+			attributes = append(attributes, attribute.Attribute{Key: "errorClass", Value: q.errorClass})
+		}
+		setTelemetryMetrics(newTelemetryMetricSet(instanceEntity, host, "query", attributes...), []telemetryMetric{
+			{"query.durationInMilliseconds", float64(q.duration.Microseconds()) / 1000},
+			{"query.rowCount", q.rows},
+			{"query.executions", q.executions},
+			{"query.errors", q.errors},
+		})
+	}
+
+	setTelemetryMetrics(newTelemetryMetricSet(instanceEntity, host, "run"), []telemetryMetric{
+		{"run.durationInMilliseconds", float64(time.Since(t.start).Microseconds()) / 1000},
+		{"run.queryDefinitions", len(names)},
+		{"run.queryExecutions", executions},
+		{"run.queryErrors", errorCount},
+		{"run.queriesFailed", statusCount[queryStatusError]},
+		{"run.queriesSkipped", statusCount[queryStatusSkipped]},
+		{"run.queriesDisabled", statusCount[queryStatusDisabled]},
+		{"run.queriesUnsupported", statusCount[queryStatusUnsupported]},
+	})
+}
+
+type telemetryMetric struct {
+	name  string
+	value interface{}
+}
+
+func newTelemetryMetricSet(instanceEntity *integration.Entity, host, scope string, attributes ...attribute.Attribute) *metric.Set {
+	attributes = append([]attribute.Attribute{
+		{Key: "displayName", Value: instanceEntity.Metadata.Name},
+		{Key: "entityName", Value: instanceEntity.Metadata.Namespace + ":" + instanceEntity.Metadata.Name},
+		{Key: "host", Value: host},
+		{Key: "instance", Value: instanceEntity.Metadata.Name},
+		{Key: "scope", Value: scope},
+	}, attributes...)
+
+	return instanceEntity.NewMetricSet("MssqlIntegrationSample", attributes...)
+}
+
+func setTelemetryMetrics(metricSet *metric.Set, metrics []telemetryMetric) {
+	for _, m := range metrics {
+		if err := metricSet.SetMetric(m.name, m.value, metric.GAUGE); err != nil {
+			log.Error("Could not set integration metric '%s': %s", m.name, err.Error())
+		}
+	}
+}
+
+// classifyError returns the class of a query error, used to group failures without the full message
+func classifyError(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return errorClassTimeout
+	case errors.Is(err, context.Canceled):
+		return errorClassCancelled
+	case errors.Is(err, driver.ErrBadConn):
+		return errorClassConnection
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return errorClassTimeout
+		}
+		return errorClassConnection
+	}
+
+	var sqlErr mssql.Error
+	if errors.As(err, &sqlErr) {
+		switch {
+		case permissionErrorNumbers[sqlErr.Number]:
+			return errorClassPermission
+		case invalidObjectErrorNumbers[sqlErr.Number]:
+			return errorClassInvalidObject
+		}
+	}
+
+	return errorClassQuery
+}
+
+// modelsLen returns the number of rows loaded into a slice of data models
+func modelsLen(models interface{}) int {
+	return reflect.Indirect(reflect.ValueOf(models)).Len()
+}
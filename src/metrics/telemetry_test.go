@@ -0,0 +1,247 @@
+package metrics
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func Test_Telemetry_Populate(t *testing.T) {
+	i, e := createTestEntity(t)
+
+	telemetry := NewTelemetry()
+	telemetry.recordQuery("instance_memory", 20*time.Millisecond, 1, nil)
+	telemetry.recordQuery("database_reserve_space", 10*time.Millisecond, 1, nil)
+	telemetry.recordQuery("database_reserve_space", 30*time.Millisecond, 0, mssql.Error{Number: 916})
+	telemetry.recordDisabled(instanceBufferDefinitions...)
+	telemetry.recordSkipped(diskMetricInBytesDefination...)
+	telemetry.Populate(e, "testhost")
+
+	samples := map[string]map[string]interface{}{}
+	for _, metricSet := range i.Entities[0].Metrics {
+		assert.Equal(t, "MssqlIntegrationSample", metricSet.Metrics["event_type"])
+		name, _ := metricSet.Metrics["queryName"].(string)
+		samples[name] = metricSet.Metrics
+	}
+	assert.Len(t, samples, 5)
+
+	assert.Equal(t, "ok", samples["instance_memory"]["status"])
+	assert.Equal(t, float64(20), samples["instance_memory"]["query.durationInMilliseconds"])
+	assert.Equal(t, float64(1), samples["instance_memory"]["query.rowCount"])
+
+	reserveSpace := samples["database_reserve_space"]
+	assert.Equal(t, "error", reserveSpace["status"])
+	assert.Equal(t, "permission", reserveSpace["errorClass"])
+	assert.Equal(t, float64(40), reserveSpace["query.durationInMilliseconds"])
+	assert.Equal(t, float64(2), reserveSpace["query.executions"])
+	assert.Equal(t, float64(1), reserveSpace["query.errors"])
+
+	assert.Equal(t, "disabled", samples["instance_buffer_pool_size"]["status"])
+	assert.Equal(t, "skipped", samples["instance_disk_space"]["status"])
+
+	// the totals of the run have no query name
+	run := samples[""]
+	assert.Equal(t, "run", run["scope"])
+	assert.Equal(t, float64(4), run["run.queryDefinitions"])
+	assert.Equal(t, float64(3), run["run.queryExecutions"])
+	assert.Equal(t, float64(1), run["run.queryErrors"])
+	assert.Equal(t, float64(1), run["run.queriesFailed"])
+	assert.Equal(t, float64(1), run["run.queriesSkipped"])
+	assert.Equal(t, float64(1), run["run.queriesDisabled"])
+}
+
+func Test_Telemetry_Nil(t *testing.T) {
+	_, e := createTestEntity(t)
+
+	var telemetry *Telemetry
+	telemetry.recordQuery("instance_memory", time.Millisecond, 1, nil)
+	telemetry.recordDisabled(instanceBufferDefinitions...)
+	telemetry.Populate(e, "testhost")
+
+	assert.Empty(t, e.Metrics)
+}
+
+func Test_populateInstanceMetrics_Telemetry(t *testing.T) {
+	_, e := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	mock.ExpectQuery(`SELECT Count\(dbid\) AS instance_active_connections`).
+		WillReturnRows(sqlmock.NewRows([]string{"instance_active_connections"}).AddRow(3))
+	mock.ExpectQuery(`SELECT Sum\(runnable_tasks_count\)`).
+		WillReturnError(mssql.Error{Number: 208, Message: "Invalid object name"})
+
+	telemetry := NewTelemetry()
+	PopulateInstanceMetrics(e, conn, args.ArgumentList{EnableDiskMetricsInBytes: true}, telemetry)
+
+	assert.Equal(t, queryStatusOK, telemetry.queries["instance_active_connections"].status)
+	assert.Equal(t, 1, telemetry.queries["instance_active_connections"].rows)
+	assert.Equal(t, queryStatusError, telemetry.queries["instance_runnable_tasks"].status)
+	assert.Equal(t, errorClassInvalidObject, telemetry.queries["instance_runnable_tasks"].errorClass)
+	assert.Equal(t, queryStatusDisabled, telemetry.queries["instance_buffer_pool_size"].status)
+	assert.Equal(t, 1, telemetry.queries["instance_disk_space"].executions)
+}
+
+func Test_populateInstanceMetrics_Unsupported(t *testing.T) {
+	_, e := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+	mock.MatchExpectationsInOrder(false)
+	conn.ServerInfo = &connection.ServerInfo{MajorVersion: 12, EngineEdition: connection.EngineEditionAzureSQLDatabase, Platform: connection.PlatformAzure}
+
+	mock.ExpectQuery(`SELECT Count\(\*\) AS instance_active_connections FROM sys\.dm_exec_sessions`).
+		WillReturnRows(sqlmock.NewRows([]string{"instance_active_connections"}).AddRow(3))
+
+	telemetry := NewTelemetry()
+	PopulateInstanceMetrics(e, conn, args.ArgumentList{EnableDiskMetricsInBytes: true}, telemetry)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, float64(3), e.Metrics[0].Metrics["activeConnections"])
+	assert.Equal(t, queryStatusOK, telemetry.queries["instance_active_connections"].status)
+	assert.Equal(t, queryStatusUnsupported, telemetry.queries["instance_memory"].status)
+	assert.Equal(t, queryStatusUnsupported, telemetry.queries["instance_disk_space"].status)
+}
+
+func Test_classifyError(t *testing.T) {
+	testCases := []struct {
+		err      error
+		expected string
+	}{
+		{context.DeadlineExceeded, errorClassTimeout},
+		{fmt.Errorf("running query: %w", context.Canceled), errorClassCancelled},
+		{driver.ErrBadConn, errorClassConnection},
+		{mssql.Error{Number: 229}, errorClassPermission},
+		{mssql.Error{Number: 208}, errorClassInvalidObject},
+		{mssql.Error{Number: 8134}, errorClassQuery},
+		{errors.New("unknown"), errorClassQuery},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, classifyError(tc.err), tc.err.Error())
+	}
+}
+
+func Test_populateDatabaseMetrics_AzureSQLDatabase(t *testing.T) {
+	i, _ := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+	conn.ServerInfo = &connection.ServerInfo{MajorVersion: 12, EngineEdition: connection.EngineEditionAzureSQLDatabase, Platform: connection.PlatformAzure}
+
+	// no user databases, so no connection is made to them
+	mock.ExpectQuery(`select name as db_name from sys\.databases`).
+		WillReturnRows(sqlmock.NewRows([]string{"db_name"}))
+
+	telemetry := NewTelemetry()
+	arguments := args.ArgumentList{EnableBufferMetrics: true, EnableDatabaseReserveMetrics: true}
+	_, err := PopulateDatabaseMetrics(i, "MSSQL", conn, arguments, telemetry)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// queries using server wide views or USE do not run on Azure SQL Database
+	assert.Equal(t, queryStatusUnsupported, telemetry.queries["database_log_growth"].status)
+	assert.Equal(t, queryStatusUnsupported, telemetry.queries["database_buffer_pool_size"].status)
+	assert.Equal(t, queryStatusUnsupported, telemetry.queries["database_reserve_space"].status)
+	assert.NotContains(t, telemetry.queries, "azure_database_resource_stats")
+}
+
+func Test_processAzureDatabaseDefinitions_Unsupported(t *testing.T) {
+	conn, _ := connection.CreateMockSQL(t)
+	defer conn.Close()
+	conn.ServerInfo = &connection.ServerInfo{MajorVersion: 16, EngineEdition: connection.EngineEditionEnterprise, Platform: connection.PlatformWindows}
+
+	telemetry := NewTelemetry()
+	modelChan := make(chan interface{}, 10)
+	processAzureDatabaseDefinitions(conn, telemetry, args.ArgumentList{}, []string{"db1"}, modelChan)
+
+	assert.Empty(t, modelChan)
+	assert.NotContains(t, telemetry.queries, "azure_database_resource_stats")
+	assert.NotContains(t, telemetry.queries, "azure_database_reserve_space")
+}
+
+func Test_populateDatabaseMetrics_DiscoveryTelemetry(t *testing.T) {
+	i, _ := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	mock.ExpectQuery(`select name as db_name from sys\.databases`).WillReturnError(mssql.Error{Number: 229})
+
+	telemetry := NewTelemetry()
+	_, err := PopulateDatabaseMetrics(i, "MSSQL", conn, args.ArgumentList{}, telemetry)
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	discovery := telemetry.queries[databaseDiscoveryTelemetry]
+	assert.Equal(t, queryStatusError, discovery.status)
+	assert.Equal(t, errorClassPermission, discovery.errorClass)
+	assert.Equal(t, 1, discovery.executions)
+}
+
+func Test_populateCustomQueryMetrics_Telemetry(t *testing.T) {
+	_, e := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	config := filepath.Join(t.TempDir(), "custom-queries.yml")
+	assert.NoError(t, os.WriteFile(config, []byte(`queries:
+  - query: SELECT name, size FROM files
+    event_type: DatabaseFileSample
+  - query: SELECT reads FROM stats
+    prefix: stats.
+  - query: SELECT 1 AS metric_value
+`), 0600))
+	mock.ExpectQuery(`SELECT name, size FROM files`).WillReturnRows(sqlmock.NewRows([]string{"name", "size"}).AddRow("data", 8).AddRow("log", 2))
+	mock.ExpectQuery(`SELECT reads FROM stats`).WillReturnError(mssql.Error{Number: 208})
+
+	// the connection is lost before the last query runs
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled, _ := connection.CreateMockSQLContext(t, ctx)
+	defer cancelled.Close()
+	cancel()
+
+	telemetry := NewTelemetry()
+	arguments := args.ArgumentList{CustomMetricsConfig: config, MaxConcurrentDatabaseQueries: 1}
+	PopulateCustomQueryMetrics(e, conn, arguments, nil, nil, telemetry)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	files := telemetry.queries["custom_query:DatabaseFileSample"]
+	assert.Equal(t, queryStatusOK, files.status)
+	assert.Equal(t, 2, files.rows)
+	stats := telemetry.queries["custom_query:stats."]
+	assert.Equal(t, queryStatusError, stats.status)
+	assert.Equal(t, errorClassInvalidObject, stats.errorClass)
+	assert.Equal(t, queryStatusError, telemetry.queries["custom_query:query_3"].status)
+
+	telemetry = NewTelemetry()
+	PopulateCustomQueryMetrics(e, cancelled, arguments, nil, nil, telemetry)
+	assert.Equal(t, queryStatusSkipped, telemetry.queries["custom_query:DatabaseFileSample"].status)
+	assert.Equal(t, queryStatusSkipped, telemetry.queries["custom_query:query_3"].status)
+}
+
+func Test_populateCustomQueryMetrics_InvalidConfigTelemetry(t *testing.T) {
+	_, e := createTestEntity(t)
+
+	conn, _ := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	telemetry := NewTelemetry()
+	arguments := args.ArgumentList{CustomMetricsConfig: filepath.Join(t.TempDir(), "missing.yml")}
+	PopulateCustomQueryMetrics(e, conn, arguments, nil, nil, telemetry)
+	assert.Equal(t, queryStatusError, telemetry.queries[customMetricsConfigTelemetry].status)
+}
@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/state"
+)
+
+// waitStatsSnapshotKey stores the wait statistics of the previous collection
+const waitStatsSnapshotKey = "waitStats.snapshot"
+
+// waitStatsState is the state kept between collections
+type waitStatsState struct {
+	// CollectedAt is when the statistics were collected, as Unix time in nanoseconds
+	CollectedAt int64
+	WaitTypes   map[string]waitStatsSnapshot
+}
+
+// waitStatsSnapshot are the cumulative statistics of a wait type in the previous collection
+type waitStatsSnapshot struct {
+	WaitTime       int64
+	SignalWaitTime int64
+	WaitCount      int64
+}
+
+// waitStatsDelta are the statistics of a wait type in the interval between collections
+type waitStatsDelta struct {
+	waitType       string
+	waitTime       int64
+	signalWaitTime int64
+	waitCount      int64
+}
+
+// PopulateWaitStatsMetrics reports an MssqlWaitSample for the wait types with the most wait time since the
+// previous collection. The cumulative statistics of sys.dm_os_wait_stats are kept in the state of the instance
+// to compute the ones of the interval, so the first collection only records the baseline.
+func PopulateWaitStatsMetrics(instanceEntity *integration.Entity, con *connection.SQLConnection, arguments args.ArgumentList, instanceState *state.State, telemetry *Telemetry) {
+	if instanceState == nil {
+		telemetry.recordSkipped(waitTimeDefinition)
+		return
+	}
+
+	if err := con.Err(); err != nil {
+		log.Warn("Skipping wait time queries: %s", err.Error())
+		telemetry.recordSkipped(waitTimeDefinition)
+		return
+	}
+
+	queryDef, ok := waitTimeDefinition.forServer(con.ServerInfo)
+	if !ok {
+		telemetry.recordUnsupported(waitTimeDefinition)
+		return
+	}
+
+	models := make([]waitStatsModel, 0)
+	if err := runQueryDefinition(con, telemetry, queryDef, queryDef.GetQuery(), &models); err != nil {
+		log.Error("Could not execute query: %s", err.Error())
+		return
+	}
+	now := time.Now()
+
+	var previous waitStatsState
+	hasBaseline := instanceState.Counters(waitStatsSnapshotKey, &previous) && previous.CollectedAt > 0
+	interval := now.Sub(time.Unix(0, previous.CollectedAt))
+
+	excluded := arguments.ExcludedWaitTypes()
+	current := make(map[string]waitStatsSnapshot, len(models))
+	for _, model := range models {
+		if model.WaitType == nil || model.WaitTime == nil {
+			continue
+		}
+		if (!arguments.IncludeBenignWaits && benignWaitTypes[*model.WaitType]) || matchWaitType(excluded, *model.WaitType) {
+			continue
+		}
+
+		snapshot := waitStatsSnapshot{WaitTime: *model.WaitTime}
+		if model.SignalWaitTime != nil {
+			snapshot.SignalWaitTime = *model.SignalWaitTime
+		}
+		if model.WaitCount != nil {
+			snapshot.WaitCount = *model.WaitCount
+		}
+		current[*model.WaitType] = snapshot
+	}
+	instanceState.SetCounters(waitStatsSnapshotKey, waitStatsState{CollectedAt: now.UnixNano(), WaitTypes: current})
+
+	if !hasBaseline || interval < time.Second {
+		return
+	}
+
+	for _, delta := range topWaits(waitStatsDeltas(previous.WaitTypes, current), arguments.WaitStatsTopN) {
+		populateWaitSample(instanceEntity, con.Host, delta, interval)
+	}
+}
+
+// waitStatsDeltas returns the statistics of the wait types that waited in the interval between two collections.
+// Wait types whose statistics went backwards, as they were cleared, are left out, as are the ones not in the
+// previous collection, whose statistics may be the ones since the instance started.
+func waitStatsDeltas(previous, current map[string]waitStatsSnapshot) []*waitStatsDelta {
+	deltas := make([]*waitStatsDelta, 0)
+	for waitType, stats := range current {
+		before, ok := previous[waitType]
+		if !ok {
+			continue
+		}
+
+		waitTime, ok := state.CounterDelta(before.WaitTime, stats.WaitTime)
+		if !ok || waitTime == 0 {
+			continue
+		}
+		signalWaitTime, ok := state.CounterDelta(before.SignalWaitTime, stats.SignalWaitTime)
+		if !ok {
+			continue
+		}
+		waitCount, ok := state.CounterDelta(before.WaitCount, stats.WaitCount)
+		if !ok {
+			continue
+		}
+
+		deltas = append(deltas, &waitStatsDelta{
+			waitType:       waitType,
+			waitTime:       waitTime,
+			signalWaitTime: signalWaitTime,
+			waitCount:      waitCount,
+		})
+	}
+	return deltas
+}
+
+// topWaits returns the topN wait types with the most wait time, all of them if topN is 0
+func topWaits(deltas []*waitStatsDelta, topN int) []*waitStatsDelta {
+	sort.Slice(deltas, func(a, b int) bool {
+		if deltas[a].waitTime != deltas[b].waitTime {
+			return deltas[a].waitTime > deltas[b].waitTime
+		}
+		return deltas[a].waitType < deltas[b].waitType
+	})
+
+	if topN > 0 && len(deltas) > topN {
+		return deltas[:topN]
+	}
+	return deltas
+}
+
+func populateWaitSample(instanceEntity *integration.Entity, host string, delta *waitStatsDelta, interval time.Duration) {
+	metricSet := instanceEntity.NewMetricSet("MssqlWaitSample",
+		attribute.Attribute{Key: "displayName", Value: instanceEntity.Metadata.Name},
+		attribute.Attribute{Key: "entityName", Value: instanceEntity.Metadata.Namespace + ":" + instanceEntity.Metadata.Name},
+		attribute.Attribute{Key: "waitType", Value: delta.waitType},
+		attribute.Attribute{Key: "waitCategory", Value: waitCategory(delta.waitType)},
+		attribute.Attribute{Key: "host", Value: host},
+		attribute.Attribute{Key: "instance", Value: instanceEntity.Metadata.Name},
+	)
+
+	seconds := interval.Seconds()
+	metrics := map[string]interface{}{
+		"system.waitTimeCount":                   delta.waitCount,
+		"system.waitTimeInMillisecondsPerSecond": float64(delta.waitTime) / seconds,
+		"wait.timeInMilliseconds":                delta.waitTime,
+		"wait.resourceTimeInMilliseconds":        delta.waitTime - delta.signalWaitTime,
+		"wait.signalTimeInMilliseconds":          delta.signalWaitTime,
+		"wait.waitsPerSecond":                    float64(delta.waitCount) / seconds,
+	}
+	if delta.waitCount > 0 {
+		metrics["wait.averageTimeInMilliseconds"] = float64(delta.waitTime) / float64(delta.waitCount)
+	}
+
+	for name, value := range metrics {
+		if err := metricSet.SetMetric(name, value, metric.GAUGE); err != nil {
+			log.Error("Could not set wait time metric '%s' for wait type '%s': %s", name, delta.waitType, err.Error())
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"path"
+)
+
+// waitStatsModel is the cumulative statistics of a wait type since the instance started
+type waitStatsModel struct {
+	WaitType       *string `db:"wait_type"`
+	WaitTime       *int64  `db:"wait_time"`
+	SignalWaitTime *int64  `db:"signal_wait_time"`
+	WaitCount      *int64  `db:"waiting_tasks_count"`
+}
+
+var waitTimeDefinition = &QueryDefinition{
+	name: "instance_wait_types",
+	query: `SELECT wait_type, wait_time_ms AS wait_time, signal_wait_time_ms AS signal_wait_time, waiting_tasks_count
+	FROM sys.dm_os_wait_stats wait_stats
+	WHERE wait_time_ms != 0`,
+	dataModels: &[]waitStatsModel{},
+}
+
+// benignWaitTypes are idle and background waits, which grow whether or not the instance is busy
+var benignWaitTypes = map[string]bool{
+	"BROKER_EVENTHANDLER": true, "BROKER_RECEIVE_WAITFOR": true, "BROKER_TASK_STOP": true,
+	"BROKER_TO_FLUSH": true, "BROKER_TRANSMITTER": true, "CHECKPOINT_QUEUE": true,
+	"CHKPT": true, "CLR_AUTO_EVENT": true, "CLR_MANUAL_EVENT": true,
+	"CLR_SEMAPHORE": true, "CXCONSUMER": true, "DBMIRROR_DBM_EVENT": true,
+	"DBMIRROR_EVENTS_QUEUE": true, "DBMIRROR_WORKER_QUEUE": true, "DBMIRRORING_CMD": true,
+	"DIRTY_PAGE_POLL": true, "DISPATCHER_QUEUE_SEMAPHORE": true, "EXECSYNC": true,
+	"FSAGENT": true, "FT_IFTS_SCHEDULER_IDLE_WAIT": true, "FT_IFTSHC_MUTEX": true,
+	"HADR_CLUSAPI_CALL": true, "HADR_FILESTREAM_IOMGR_IOCOMPLETION": true, "HADR_LOGCAPTURE_WAIT": true,
+	"HADR_NOTIFICATION_DEQUEUE": true, "HADR_TIMER_TASK": true, "HADR_WORK_QUEUE": true,
+	"KSOURCE_WAKEUP": true, "LAZYWRITER_SLEEP": true, "LOGMGR_QUEUE": true,
+	"MEMORY_ALLOCATION_EXT": true, "ONDEMAND_TASK_QUEUE": true, "PARALLEL_REDO_DRAIN_WORKER": true,
+	"PARALLEL_REDO_LOG_CACHE": true, "PARALLEL_REDO_TRAN_LIST": true, "PARALLEL_REDO_WORKER_SYNC": true,
+	"PARALLEL_REDO_WORKER_WAIT_WORK": true, "PREEMPTIVE_OS_FLUSHFILEBUFFERS": true, "PREEMPTIVE_XE_GETTARGETSTATE": true,
+	"PVS_PREALLOCATE": true, "PWAIT_ALL_COMPONENTS_INITIALIZED": true, "PWAIT_DIRECTLOGCONSUMER_GETNEXT": true,
+	"PWAIT_EXTENSIBILITY_CLEANUP_TASK": true, "QDS_ASYNC_QUEUE": true, "QDS_CLEANUP_STALE_QUERIES_TASK_MAIN_LOOP_SLEEP": true,
+	"QDS_PERSIST_TASK_MAIN_LOOP_SLEEP": true, "QDS_SHUTDOWN_QUEUE": true, "REDO_THREAD_PENDING_WORK": true,
+	"REQUEST_FOR_DEADLOCK_SEARCH": true, "RESOURCE_QUEUE": true, "SERVER_IDLE_CHECK": true,
+	"SLEEP_BPOOL_FLUSH": true, "SLEEP_DBSTARTUP": true, "SLEEP_DCOMSTARTUP": true,
+	"SLEEP_MASTERDBREADY": true, "SLEEP_MASTERMDREADY": true, "SLEEP_MASTERUPGRADED": true,
+	"SLEEP_MSDBSTARTUP": true, "SLEEP_SYSTEMTASK": true, "SLEEP_TASK": true,
+	"SLEEP_TEMPDBSTARTUP": true, "SNI_HTTP_ACCEPT": true, "SOS_WORK_DISPATCHER": true,
+	"SP_SERVER_DIAGNOSTICS_SLEEP": true, "SQLTRACE_BUFFER_FLUSH": true, "SQLTRACE_INCREMENTAL_FLUSH_SLEEP": true,
+	"SQLTRACE_WAIT_ENTRIES": true, "VDI_CLIENT_OTHER": true, "WAIT_FOR_RESULTS": true,
+	"WAITFOR": true, "WAITFOR_TASKSHUTDOWN": true, "WAIT_XTP_CKPT_CLOSE": true,
+	"WAIT_XTP_HOST_WAIT": true, "WAIT_XTP_OFFLINE_CKPT_NEW_LOG": true, "WAIT_XTP_RECOVERY": true,
+	"XE_DISPATCHER_JOIN": true, "XE_DISPATCHER_WAIT": true, "XE_TIMER_EVENT": true,
+}
+
+// waitCategories groups the wait types as Query Store does, the first category matching a wait type is used.
+// Idle, user and log rate governor waits come before the categories whose prefixes also match them.
+var waitCategories = []struct {
+	name      string
+	waitTypes []string
+}{
+	{"Idle", []string{"SLEEP_*", "LAZYWRITER_SLEEP", "SQLTRACE_BUFFER_FLUSH", "SQLTRACE_INCREMENTAL_FLUSH_SLEEP", "SQLTRACE_WAIT_ENTRIES", "FT_IFTS_SCHEDULER_IDLE_WAIT", "XE_DISPATCHER_WAIT", "REQUEST_FOR_DEADLOCK_SEARCH", "LOGMGR_QUEUE", "ONDEMAND_TASK_QUEUE", "CHECKPOINT_QUEUE", "XE_TIMER_EVENT"}},
+	{"CPU", []string{"SOS_SCHEDULER_YIELD"}},
+	{"Worker Thread", []string{"THREADPOOL"}},
+	{"Lock", []string{"LCK_M_*"}},
+	{"Buffer Latch", []string{"PAGELATCH_*"}},
+	{"Buffer IO", []string{"PAGEIOLATCH_*"}},
+	{"Latch", []string{"LATCH_*"}},
+	{"Compilation", []string{"RESOURCE_SEMAPHORE_QUERY_COMPILE"}},
+	{"SQL CLR", []string{"CLR*", "SQLCLR*"}},
+	{"Mirroring", []string{"DBMIRROR*"}},
+	{"Transaction", []string{"XACT*", "DTC*", "TRAN_MARKLATCH_*", "MSQL_XACT_*", "TRANSACTION_MUTEX"}},
+	{"Preemptive", []string{"PREEMPTIVE_*"}},
+	{"User Wait", []string{"WAITFOR", "WAIT_FOR_RESULTS", "BROKER_RECEIVE_WAITFOR"}},
+	{"Service Broker", []string{"BROKER_*"}},
+	{"Tran Log IO", []string{"LOGMGR", "LOGBUFFER", "LOGMGR_RESERVE_APPEND", "LOGMGR_FLUSH", "LOGMGR_PMM_LOG", "CHKPT", "WRITELOG"}},
+	{"Network IO", []string{"ASYNC_NETWORK_IO", "NET_WAITFOR_PACKET", "PROXY_NETWORK_IO", "EXTERNAL_SCRIPT_NETWORK_IOF"}},
+	{"Parallelism", []string{"CXPACKET", "CXCONSUMER", "CXSYNC_*", "EXCHANGE", "HT*", "BMP*", "BP*"}},
+	{"Memory", []string{"RESOURCE_SEMAPHORE", "CMEMTHREAD", "CMEMPARTITIONED", "EE_PMOLOCK", "MEMORY_ALLOCATION_EXT", "RESERVED_MEMORY_ALLOCATION_EXT", "MEMORY_GRANT_UPDATE"}},
+	{"Tracing", []string{"TRACE*", "SQLTRACE*", "QUERY_TRACEOUT"}},
+	{"Full Text Search", []string{"FT_*", "MSSEARCH", "FULLTEXT GATHERER"}},
+	{"Other Disk IO", []string{"ASYNC_IO_COMPLETION", "IO_COMPLETION", "BACKUPIO", "WRITE_COMPLETION", "IO_QUEUE_LIMIT", "IO_RETRY"}},
+	{"Log Rate Governor", []string{"LOG_RATE_GOVERNOR", "POOL_LOG_RATE_GOVERNOR", "HADR_THROTTLE_LOG_RATE_GOVERNOR", "INSTANCE_LOG_RATE_GOVERNOR"}},
+	{"Replication", []string{"SE_REPL_*", "REPL_*", "HADR_*", "PWAIT_HADR_*"}},
+}
+
+// waitCategory returns the category of a wait type, Other if unknown
+func waitCategory(waitType string) string {
+	for _, category := range waitCategories {
+		if matchWaitType(category.waitTypes, waitType) {
+			return category.name
+		}
+	}
+	return "Other"
+}
+
+// matchWaitType returns true if the wait type matches any pattern, globs supporting '*' and '?'
+func matchWaitType(patterns []string, waitType string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, waitType); matched {
+			return true
+		}
+	}
+	return false
+}
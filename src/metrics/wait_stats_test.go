@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/persist"
+	"github.com/newrelic/nri-mssql/src/args"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/newrelic/nri-mssql/src/state"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func waitStatsRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"wait_type", "wait_time", "signal_wait_time", "waiting_tasks_count"}).
+		AddRow("LCK_M_S", 6638, 38, 11).
+		AddRow("PAGEIOLATCH_SH", 2000, 100, 400).
+		AddRow("LAZYWRITER_SLEEP", 1118786296, 0, 1126388).
+		AddRow("PREEMPTIVE_OS_DEVICEOPS", 119, 0, 90).
+		AddRow("SOS_SCHEDULER_YIELD", 900, 900, 3000)
+}
+
+func Test_populateWaitStatsMetrics(t *testing.T) {
+	_, e := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	mock.ExpectQuery(`SELECT wait_type, wait_time_ms AS wait_time, signal_wait_time_ms AS signal_wait_time, waiting_tasks_count\s*FROM sys.dm_os_wait_stats wait_stats\s*WHERE wait_time_ms != 0`).
+		WillReturnRows(waitStatsRows())
+
+	// the previous collection was a minute ago
+	store := persist.NewInMemoryStore()
+	store.Set(waitStatsSnapshotKey, waitStatsState{CollectedAt: time.Now().Add(-time.Minute).UnixNano(), WaitTypes: map[string]waitStatsSnapshot{
+		"LCK_M_S":                 {WaitTime: 638, SignalWaitTime: 8, WaitCount: 1},
+		"PAGEIOLATCH_SH":          {WaitTime: 1400, SignalWaitTime: 70, WaitCount: 250},
+		"PREEMPTIVE_OS_DEVICEOPS": {WaitTime: 119, WaitCount: 90},
+		"SOS_SCHEDULER_YIELD":     {WaitTime: 1000, SignalWaitTime: 1000, WaitCount: 3500},
+	}})
+
+	telemetry := NewTelemetry()
+	arguments := args.ArgumentList{WaitStatsTopN: 20, WaitStatsExclude: "preemptive_*"}
+	PopulateWaitStatsMetrics(e, conn, arguments, state.New(store, time.Time{}), telemetry)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, 1, telemetry.queries["instance_wait_types"].executions)
+
+	// benign and excluded waits are left out, as are the ones cleared since the previous collection
+	assert.Len(t, e.Metrics, 2)
+	lock := e.Metrics[0].Metrics
+	assert.Equal(t, "MssqlWaitSample", lock["event_type"])
+	assert.Equal(t, "LCK_M_S", lock["waitType"])
+	assert.Equal(t, "Lock", lock["waitCategory"])
+	assert.Equal(t, float64(10), lock["system.waitTimeCount"])
+	assert.Equal(t, float64(6000), lock["wait.timeInMilliseconds"])
+	assert.Equal(t, float64(5970), lock["wait.resourceTimeInMilliseconds"])
+	assert.Equal(t, float64(30), lock["wait.signalTimeInMilliseconds"])
+	assert.Equal(t, float64(600), lock["wait.averageTimeInMilliseconds"])
+	assert.InDelta(t, 100, lock["system.waitTimeInMillisecondsPerSecond"], 2)
+	assert.InDelta(t, 10.0/60, lock["wait.waitsPerSecond"], 0.01)
+
+	io := e.Metrics[1].Metrics
+	assert.Equal(t, "PAGEIOLATCH_SH", io["waitType"])
+	assert.Equal(t, "Buffer IO", io["waitCategory"])
+	assert.Equal(t, float64(600), io["wait.timeInMilliseconds"])
+
+	var stored waitStatsState
+	_, err := store.Get(waitStatsSnapshotKey, &stored)
+	assert.NoError(t, err)
+	assert.Equal(t, waitStatsSnapshot{WaitTime: 900, SignalWaitTime: 900, WaitCount: 3000}, stored.WaitTypes["SOS_SCHEDULER_YIELD"])
+	assert.NotContains(t, stored.WaitTypes, "LAZYWRITER_SLEEP")
+}
+
+func Test_populateWaitStatsMetrics_FirstCollection(t *testing.T) {
+	_, e := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	mock.ExpectQuery(`FROM sys.dm_os_wait_stats`).WillReturnRows(waitStatsRows())
+
+	store := persist.NewInMemoryStore()
+	PopulateWaitStatsMetrics(e, conn, args.ArgumentList{IncludeBenignWaits: true}, state.New(store, time.Time{}), nil)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// only the baseline is recorded
+	assert.Empty(t, e.Metrics)
+	var stored waitStatsState
+	_, err := store.Get(waitStatsSnapshotKey, &stored)
+	assert.NoError(t, err)
+	assert.NotZero(t, stored.CollectedAt)
+	assert.Len(t, stored.WaitTypes, 5)
+}
+
+func Test_populateWaitStatsMetrics_ShortInterval(t *testing.T) {
+	_, e := createTestEntity(t)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	mock.ExpectQuery(`FROM sys.dm_os_wait_stats`).WillReturnRows(waitStatsRows())
+
+	// the interval is not rounded to whole seconds
+	store := persist.NewInMemoryStore()
+	store.Set(waitStatsSnapshotKey, waitStatsState{CollectedAt: time.Now().Add(-1500 * time.Millisecond).UnixNano(), WaitTypes: map[string]waitStatsSnapshot{
+		"LCK_M_S": {WaitTime: 638, SignalWaitTime: 8, WaitCount: 1},
+	}})
+
+	PopulateWaitStatsMetrics(e, conn, args.ArgumentList{}, state.New(store, time.Time{}), nil)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Len(t, e.Metrics, 1)
+	assert.InDelta(t, 4000, e.Metrics[0].Metrics["system.waitTimeInMillisecondsPerSecond"], 200)
+}
+
+func Test_waitStatsDeltas_NewWaitType(t *testing.T) {
+	previous := map[string]waitStatsSnapshot{
+		"WRITELOG": {WaitTime: 100, WaitCount: 10},
+	}
+	current := map[string]waitStatsSnapshot{
+		"WRITELOG": {WaitTime: 250, WaitCount: 12},
+		// not in the previous collection, such as a wait type no longer excluded
+		"CXPACKET": {WaitTime: 98000, WaitCount: 4000},
+	}
+
+	deltas := waitStatsDeltas(previous, current)
+	assert.Len(t, deltas, 1)
+	assert.Equal(t, "WRITELOG", deltas[0].waitType)
+	assert.Equal(t, int64(150), deltas[0].waitTime)
+}
+
+func Test_topWaits(t *testing.T) {
+	deltas := []*waitStatsDelta{
+		{waitType: "CXPACKET", waitTime: 50},
+		{waitType: "WRITELOG", waitTime: 300},
+		{waitType: "LCK_M_X", waitTime: 120},
+	}
+
+	top := topWaits(deltas, 2)
+	assert.Len(t, top, 2)
+	assert.Equal(t, "WRITELOG", top[0].waitType)
+	assert.Equal(t, "LCK_M_X", top[1].waitType)
+
+	assert.Len(t, topWaits(deltas, 0), 3)
+}
+
+func Test_waitCategory(t *testing.T) {
+	testCases := map[string]string{
+		"SOS_SCHEDULER_YIELD":             "CPU",
+		"LCK_M_IX":                        "Lock",
+		"PAGELATCH_EX":                    "Buffer Latch",
+		"PAGEIOLATCH_SH":                  "Buffer IO",
+		"WRITELOG":                        "Tran Log IO",
+		"ASYNC_NETWORK_IO":                "Network IO",
+		"CXPACKET":                        "Parallelism",
+		"RESOURCE_SEMAPHORE":              "Memory",
+		"HADR_SYNC_COMMIT":                "Replication",
+		"HADR_THROTTLE_LOG_RATE_GOVERNOR": "Log Rate Governor",
+		"BROKER_RECEIVE_WAITFOR":          "User Wait",
+		"SQLTRACE_BUFFER_FLUSH":           "Idle",
+		"SOME_NEW_WAIT":                   "Other",
+	}
+
+	for waitType, category := range testCases {
+		assert.Equal(t, category, waitCategory(waitType), waitType)
+	}
+}
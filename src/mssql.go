@@ -2,10 +2,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/newrelic/infra-integrations-sdk/v3/integration"
 	"github.com/newrelic/infra-integrations-sdk/v3/log"
@@ -14,6 +19,7 @@ import (
 	"github.com/newrelic/nri-mssql/src/instance"
 	"github.com/newrelic/nri-mssql/src/inventory"
 	"github.com/newrelic/nri-mssql/src/metrics"
+	"github.com/newrelic/nri-mssql/src/state"
 )
 
 const (
@@ -56,39 +62,136 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Create a new connection
-	con, err := connection.NewConnection(&args)
+	// Remaining queries are cancelled once the collection timeout is reached, publishing what was collected
+	ctx, cancel := context.WithCancel(context.Background())
+	if args.CollectionTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(args.CollectionTimeout)*time.Second)
+	}
+	defer cancel()
+
+	// A single target is collected unless multiple targets are configured
+	if len(args.TargetsConfig) == 0 {
+		err = collectTarget(ctx, i, args)
+	} else {
+		err = collectTargets(ctx, i, args)
+	}
 	if err != nil {
-		log.Error("Error creating connection to SQL Server: %s", err.Error())
+		log.Error(err.Error())
 		os.Exit(1)
 	}
 
+	if ctx.Err() != nil {
+		log.Warn("Collection timeout of %d seconds reached, reporting the data collected so far", args.CollectionTimeout)
+	}
+
+	if err = i.Publish(); err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// collectTargets collects every target in the targets config concurrently, up to MaxConcurrentTargets
+// at a time. Failed targets are logged and an error is only returned if no target could be collected.
+func collectTargets(ctx context.Context, i *integration.Integration, arguments args.ArgumentList) error {
+	targets, err := arguments.LoadTargets()
+	if err != nil {
+		return fmt.Errorf("configuration error: %s", err)
+	}
+
+	for _, target := range targets {
+		if err := target.Validate(); err != nil {
+			return fmt.Errorf("configuration error for target '%s': %s", target.Hostname, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	var failed int32
+	semaphore := make(chan struct{}, arguments.MaxConcurrentTargets)
+	for _, target := range targets {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(target args.ArgumentList) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			if err := collectTarget(ctx, i, target); err != nil {
+				log.Error("Target '%s': %s", target.Hostname, err.Error())
+				atomic.AddInt32(&failed, 1)
+			}
+		}(target)
+	}
+	wg.Wait()
+
+	if int(failed) == len(targets) {
+		return errors.New("unable to collect any of the targets")
+	}
+
+	return nil
+}
+
+// collectTarget collects inventory and metrics of a single SQL Server instance
+func collectTarget(ctx context.Context, i *integration.Integration, arguments args.ArgumentList) error {
+	// Create a new connection
+	con, err := connection.NewConnection(ctx, &arguments)
+	if err != nil {
+		return fmt.Errorf("error creating connection to SQL Server: %s", err.Error())
+	}
+
+	// Close connection when done
+	defer con.Close()
+
 	// Create the entity for the instance
 	instanceEntity, err := instance.CreateInstanceEntity(i, con)
 	if err != nil {
-		log.Error("Unable to create entity for instance: %s", err.Error())
-		os.Exit(1)
+		return fmt.Errorf("unable to create entity for instance: %s", err.Error())
 	}
 
 	// Inventory collection
-	if args.HasInventory() {
+	if arguments.HasInventory() {
 		inventory.PopulateInventory(instanceEntity, con)
 	}
 
 	// Metric collection
-	if args.HasMetrics() {
-		if err := metrics.PopulateDatabaseMetrics(i, instanceEntity.Metadata.Name, con, args); err != nil {
+	if arguments.HasMetrics() {
+		var telemetry *metrics.Telemetry
+		if arguments.EnableIntegrationTelemetry {
+			telemetry = metrics.NewTelemetry()
+		}
+
+		dbSetLookup, err := metrics.PopulateDatabaseMetrics(i, instanceEntity.Metadata.Name, con, arguments, telemetry)
+		if err != nil {
 			log.Error("Error collecting metrics for databases: %s", err.Error())
 		}
 
-		metrics.PopulateInstanceMetrics(instanceEntity, con, args)
-	}
+		// the core instance metrics run ahead of the optional collectors, so they are the last ones
+		// skipped if the collection timeout is reached
+		metrics.PopulateInstanceMetrics(instanceEntity, con, arguments, telemetry)
 
-	// Close connection when done
-	defer con.Close()
+		metrics.PopulateAvailabilityGroupMetrics(i, instanceEntity.Metadata.Name, con, arguments, telemetry)
+		metrics.PopulateBlockingMetrics(instanceEntity, con, arguments, telemetry)
 
-	if err = i.Publish(); err != nil {
-		log.Error(err.Error())
-		os.Exit(1)
+		// collectors reporting the changes since the previous run keep them in the state of the instance
+		instanceState, err := state.Open(con, instanceEntity, arguments.TempDir, arguments.Verbose)
+		if err != nil {
+			log.Error("Unable to open the state of the instance, skipping the collectors using it: %s", err.Error())
+		}
+
+		metrics.PopulateWaitStatsMetrics(instanceEntity, con, arguments, instanceState, telemetry)
+		metrics.PopulateAgentJobMetrics(instanceEntity, con, arguments, instanceState, telemetry)
+		metrics.PopulateQueryMetrics(instanceEntity, con, arguments, instanceState, telemetry)
+		metrics.PopulateDeadlockMetrics(instanceEntity, con, arguments, instanceState, telemetry)
+		metrics.PopulateErrorLogEvents(instanceEntity, con, arguments, instanceState, telemetry)
+
+		metrics.PopulateCustomQueryMetrics(instanceEntity, con, arguments, dbSetLookup, instanceState, telemetry)
+
+		if instanceState != nil {
+			if err := instanceState.Save(); err != nil {
+				log.Error("Unable to save the state of the instance: %s", err.Error())
+			}
+		}
+
+		telemetry.Populate(instanceEntity, con.Host)
 	}
+
+	return nil
 }
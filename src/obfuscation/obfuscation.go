@@ -0,0 +1,199 @@
+// Package obfuscation removes the literals of T-SQL statements, so the query text collected does not include
+// the data in them
+package obfuscation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Modes of handling the query text reported
+const (
+	// ModeOff reports the query text as is
+	ModeOff = "off"
+	// ModeObfuscate replaces the literals with placeholders
+	ModeObfuscate = "obfuscate"
+	// ModeDropText does not report the query text
+	ModeDropText = "drop_text"
+	// ModeHashOnly reports a hash of the obfuscated query text, to tell queries apart without their text
+	ModeHashOnly = "hash_only"
+)
+
+// placeholder replaces every literal
+const placeholder = "?"
+
+// inList matches a list of placeholders in an IN predicate
+var inList = regexp.MustCompile(`(?i)\bIN\s*\(\s*[-+]?\?(?:\s*,\s*[-+]?\?)*\s*\)`)
+
+// Obfuscator handles the query text according to its mode. A nil Obfuscator obfuscates the text.
+type Obfuscator struct {
+	mode string
+}
+
+// New creates an Obfuscator for mode, ModeObfuscate if empty
+func New(mode string) (*Obfuscator, error) {
+	switch mode {
+	case "":
+		return &Obfuscator{mode: ModeObfuscate}, nil
+	case ModeOff, ModeObfuscate, ModeDropText, ModeHashOnly:
+		return &Obfuscator{mode: mode}, nil
+	default:
+		return nil, fmt.Errorf("unknown query text mode '%s', must be one of %s, %s, %s or %s", mode, ModeOff, ModeObfuscate, ModeDropText, ModeHashOnly)
+	}
+}
+
+// Apply returns the query text to report, or false if it must not be reported
+func (o *Obfuscator) Apply(text string) (string, bool) {
+	mode := ModeObfuscate
+	if o != nil {
+		mode = o.mode
+	}
+
+	switch mode {
+	case ModeOff:
+		return text, true
+	case ModeDropText:
+		return "", false
+	case ModeHashOnly:
+		sum := sha256.Sum256([]byte(Obfuscate(text)))
+		return hex.EncodeToString(sum[:]), true
+	default:
+		return Obfuscate(text), true
+	}
+}
+
+// Obfuscate replaces the string, numeric, money and binary literals of a T-SQL statement with a placeholder,
+// collapses the lists of literals in IN predicates into a single placeholder and removes comments.
+// Identifiers, including quoted ones and variables, are kept.
+func Obfuscate(query string) string {
+	var out strings.Builder
+	out.Grow(len(query))
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		afterIdentifier := i > 0 && isIdentifierChar(query[i-1])
+
+		switch {
+		case c == '\'':
+			i = skipQuoted(query, i, '\'')
+			out.WriteString(placeholder)
+		case (c == 'N' || c == 'n') && !afterIdentifier && i+1 < len(query) && query[i+1] == '\'':
+			i = skipQuoted(query, i+1, '\'')
+			out.WriteString(placeholder)
+		case c == '"':
+			end := skipQuoted(query, i, '"')
+			out.WriteString(query[i:end])
+			i = end
+		case c == '[':
+			end := skipQuoted(query, i, ']')
+			out.WriteString(query[i:end])
+			i = end
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			i = skipBlockComment(query, i)
+			if s := out.String(); len(s) > 0 && !isSpace(s[len(s)-1]) {
+				out.WriteByte(' ')
+			}
+		case afterIdentifier:
+			out.WriteByte(c)
+			i++
+		case c == '0' && i+1 < len(query) && (query[i+1] == 'x' || query[i+1] == 'X'):
+			i += 2
+			for i < len(query) && isHexDigit(query[i]) {
+				i++
+			}
+			out.WriteString(placeholder)
+		case isDigit(c) || (c == '.' || c == '$') && i+1 < len(query) && isDigit(query[i+1]):
+			i = skipNumber(query, i)
+			out.WriteString(placeholder)
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+
+	return inList.ReplaceAllString(out.String(), "IN ("+placeholder+")")
+}
+
+// skipQuoted returns the position after the quoted text starting at start, where a doubled closing
+// character is part of the text
+func skipQuoted(query string, start int, closing byte) int {
+	for i := start + 1; i < len(query); i++ {
+		if query[i] != closing {
+			continue
+		}
+		if i+1 < len(query) && query[i+1] == closing {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return len(query)
+}
+
+// skipBlockComment returns the position after the comment starting at start. T-SQL comments can be nested.
+func skipBlockComment(query string, start int) int {
+	depth := 0
+	for i := start; i+1 < len(query); i++ {
+		switch {
+		case query[i] == '/' && query[i+1] == '*':
+			depth++
+			i++
+		case query[i] == '*' && query[i+1] == '/':
+			depth--
+			i++
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(query)
+}
+
+// skipNumber returns the position after the number starting at start, Ex: 42, 3.14, .5, 1e-3 or $19.99
+func skipNumber(query string, start int) int {
+	i := start
+	if query[i] == '$' {
+		i++
+	}
+	for i < len(query) && (isDigit(query[i]) || query[i] == '.') {
+		i++
+	}
+	if i+1 < len(query) && (query[i] == 'e' || query[i] == 'E') {
+		j := i + 1
+		if query[j] == '+' || query[j] == '-' {
+			j++
+		}
+		if j < len(query) && isDigit(query[j]) {
+			i = j
+			for i < len(query) && isDigit(query[i]) {
+				i++
+			}
+		}
+	}
+	return i
+}
+
+// isIdentifierChar returns true for the characters of identifiers and variables, so the digits in
+// names such as t1 or @p1 are not taken as literals
+func isIdentifierChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || isDigit(c) || c == '_' || c == '@' || c == '#' || c == '$' || c >= 0x80
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
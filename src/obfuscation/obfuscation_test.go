@@ -0,0 +1,73 @@
+package obfuscation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObfuscate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{"String", "SELECT * FROM users WHERE email = 'jane@example.com'", "SELECT * FROM users WHERE email = ?"},
+		{"Escaped Quote", "SELECT * FROM users WHERE name = 'O''Brien' AND id = 1", "SELECT * FROM users WHERE name = ? AND id = ?"},
+		{"Unicode String", "UPDATE cards SET number = N'4111 1111 1111 1111'", "UPDATE cards SET number = ?"},
+		{"Numbers", "SELECT TOP 10 * FROM t1 WHERE price > 3.14 AND ratio < .5 AND big = 1e-3", "SELECT TOP ? * FROM t1 WHERE price > ? AND ratio < ? AND big = ?"},
+		{"Money And Binary", "INSERT INTO payments VALUES ($19.99, 0x1F2E3D)", "INSERT INTO payments VALUES (?, ?)"},
+		{"Identifiers", "SELECT [col 1], \"col'2\", @p1, #tmp2.c3 FROM dbo.table3", "SELECT [col 1], \"col'2\", @p1, #tmp2.c3 FROM dbo.table3"},
+		{"In List", "SELECT * FROM orders WHERE id IN (1, 2, -3) AND code in ('a','b')", "SELECT * FROM orders WHERE id IN (?) AND code IN (?)"},
+		{"Subquery In", "SELECT * FROM orders WHERE id IN (SELECT id FROM t WHERE x = 1)", "SELECT * FROM orders WHERE id IN (SELECT id FROM t WHERE x = ?)"},
+		{"Comments", "SELECT /* secret 'x' /* nested */ */1 -- card 4111\nFROM t", "SELECT ? \nFROM t"},
+		{"Unterminated", "SELECT 'abc", "SELECT ?"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Obfuscate(tc.query))
+		})
+	}
+}
+
+func TestObfuscator_Apply(t *testing.T) {
+	query := "SELECT * FROM users WHERE id = 42"
+
+	testCases := []struct {
+		mode     string
+		expected string
+		ok       bool
+	}{
+		{ModeOff, query, true},
+		{ModeObfuscate, "SELECT * FROM users WHERE id = ?", true},
+		{"", "SELECT * FROM users WHERE id = ?", true},
+		{ModeDropText, "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.mode, func(t *testing.T) {
+			o, err := New(tc.mode)
+			assert.NoError(t, err)
+			text, ok := o.Apply(query)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.expected, text)
+		})
+	}
+
+	// the hash of the obfuscated text is the same for any literal
+	o, _ := New(ModeHashOnly)
+	first, _ := o.Apply("SELECT * FROM users WHERE id = 42")
+	second, _ := o.Apply("SELECT * FROM users WHERE id = 7")
+	assert.Len(t, first, 64)
+	assert.Equal(t, first, second)
+
+	// a nil Obfuscator obfuscates
+	var nilObfuscator *Obfuscator
+	text, ok := nilObfuscator.Apply(query)
+	assert.True(t, ok)
+	assert.Equal(t, "SELECT * FROM users WHERE id = ?", text)
+
+	_, err := New("unknown")
+	assert.Error(t, err)
+}
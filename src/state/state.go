@@ -0,0 +1,210 @@
+// Package state keeps the state of the collection of an instance between runs of the integration, such as the
+// last event reported or the cumulative counters of the previous collection
+package state
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/infra-integrations-sdk/v3/persist"
+	"github.com/newrelic/nri-mssql/src/connection"
+)
+
+const (
+	// TTL is how long a value is kept without being updated, and a state file of an instance not collected anymore
+	TTL = 24 * time.Hour
+
+	// filePrefix is the prefix of the state files, one for each instance
+	filePrefix = "com.newrelic.mssql-"
+	// startTimeKey stores when the instance started, to detect restarts
+	startTimeKey = "instance.startTime"
+	// countersStartTimeSuffix is added to the key of counters to store when the instance started
+	countersStartTimeSuffix = ".startTime"
+
+	startTimeQuery = "SELECT sqlserver_start_time FROM sys.dm_os_sys_info"
+)
+
+// fileNameChars matches the characters replaced in the name of state files
+var fileNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// startTimeRow is a row of startTimeQuery
+type startTimeRow struct {
+	StartTime time.Time `db:"sqlserver_start_time"`
+}
+
+// State is the state of an instance kept between runs. Counters, the cumulative values of the server
+// since it started, are discarded when the instance restarts while watermarks are kept.
+type State struct {
+	store     persist.Storer
+	startTime int64
+	restarted bool
+}
+
+// Open opens the state of the instance entity, kept in a file of tempDir for each host and instance.
+// State files not updated for TTL are removed.
+func Open(con *connection.SQLConnection, instanceEntity *integration.Entity, tempDir string, verbose bool) (*State, error) {
+	key, err := instanceEntity.Key()
+	if err != nil {
+		return nil, err
+	}
+
+	path := persist.TmpPath(tempDir, fileNameChars.ReplaceAllString(fmt.Sprintf("%s%s-%s", filePrefix, con.Host, key), "_"))
+	removeStaleFiles(filepath.Dir(path))
+
+	store, err := persist.NewFileStore(path, log.NewStdErr(verbose), TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	// without the start time restarts are only detected by counters going backwards
+	var startTime time.Time
+	rows := make([]startTimeRow, 0)
+	if err := con.Query(&rows, startTimeQuery); err != nil {
+		log.Debug("Unable to get the start time of the instance: %s", err.Error())
+	} else if len(rows) == 1 {
+		startTime = rows[0].StartTime
+	}
+
+	return New(store, startTime), nil
+}
+
+// New returns the state kept in store of an instance started at startTime, zero if unknown
+func New(store persist.Storer, startTime time.Time) *State {
+	s := &State{store: store}
+	if startTime.IsZero() {
+		return s
+	}
+
+	s.startTime = startTime.Unix()
+	var previous int64
+	if _, err := store.Get(startTimeKey, &previous); err == nil && previous != s.startTime {
+		log.Debug("Instance restarted since the previous collection, discarding its counters")
+		s.restarted = true
+	}
+	store.Set(startTimeKey, s.startTime)
+
+	return s
+}
+
+// Restarted returns true if the instance restarted since the previous collection
+func (s *State) Restarted() bool {
+	return s.restarted
+}
+
+// Watermark returns the time stored in key, Ex: the time of the last event reported
+func (s *State) Watermark(key string) (time.Time, bool) {
+	var watermark time.Time
+	if !s.get(key, &watermark) {
+		return time.Time{}, false
+	}
+	return watermark, true
+}
+
+// SetWatermark stores a time in key
+func (s *State) SetWatermark(key string, watermark time.Time) {
+	s.store.Set(key, watermark)
+}
+
+// IDWatermark returns the id stored in key, Ex: the last row of a history table reported
+func (s *State) IDWatermark(key string) (int64, bool) {
+	var id int64
+	if !s.get(key, &id) {
+		return 0, false
+	}
+	return id, true
+}
+
+// SetIDWatermark stores an id in key
+func (s *State) SetIDWatermark(key string, id int64) {
+	s.store.Set(key, id)
+}
+
+// EventIDs returns the ids stored in key, Ex: the events reported with the time of a watermark
+func (s *State) EventIDs(key string) []string {
+	var ids []string
+	if !s.get(key, &ids) {
+		return nil
+	}
+	return ids
+}
+
+// SetEventIDs stores ids in key
+func (s *State) SetEventIDs(key string, ids []string) {
+	s.store.Set(key, ids)
+}
+
+// Counters reads the counters stored in key into countersPtr. It returns false if there are no counters or they
+// were stored before the instance restarted. The store only keeps when they were stored in seconds, so counters
+// needing the time they were collected at keep it themselves.
+func (s *State) Counters(key string, countersPtr interface{}) bool {
+	var startTime int64
+	if s.get(key+countersStartTimeSuffix, &startTime) && startTime != s.startTime {
+		return false
+	}
+
+	if _, err := s.store.Get(key, countersPtr); err != nil {
+		if err != persist.ErrNotFound {
+			log.Warn("Unable to read '%s' from the state of the instance: %s", key, err.Error())
+		}
+		return false
+	}
+	return true
+}
+
+// SetCounters stores counters in key, such as a map or a struct of cumulative values
+func (s *State) SetCounters(key string, counters interface{}) {
+	s.store.Set(key, counters)
+	s.store.Set(key+countersStartTimeSuffix, s.startTime)
+}
+
+// Delete removes key
+func (s *State) Delete(key string) {
+	if err := s.store.Delete(key); err != nil {
+		log.Debug("Unable to delete '%s' from the state of the instance: %s", key, err.Error())
+	}
+}
+
+// Save writes the state, removing the values not updated for TTL
+func (s *State) Save() error {
+	return s.store.Save()
+}
+
+func (s *State) get(key string, valuePtr interface{}) bool {
+	if _, err := s.store.Get(key, valuePtr); err != nil {
+		if err != persist.ErrNotFound {
+			log.Warn("Unable to read '%s' from the state of the instance: %s", key, err.Error())
+		}
+		return false
+	}
+	return true
+}
+
+// CounterDelta returns the increase of a cumulative counter since its previous value. It returns false
+// if the counter went backwards, as it restarted.
+func CounterDelta(previous, current int64) (int64, bool) {
+	if current < previous {
+		return 0, false
+	}
+	return current - previous, true
+}
+
+// removeStaleFiles removes the state files of instances not collected for TTL
+func removeStaleFiles(dir string) {
+	files, err := filepath.Glob(filepath.Join(dir, filePrefix+"*.json"))
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil && time.Since(info.ModTime()) > TTL {
+			if err := os.Remove(file); err != nil {
+				log.Debug("Unable to remove the state file %s: %s", file, err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/persist"
+	"github.com/newrelic/nri-mssql/src/connection"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+type testCounters struct {
+	Reads  int64
+	Writes int64
+}
+
+func openTestState(t *testing.T, dir string, startTime time.Time) *State {
+	i, err := integration.New("test", "1.0.0")
+	assert.NoError(t, err)
+	e, err := i.EntityReportedVia("testhost", "SQLSERVER01", "ms-instance")
+	assert.NoError(t, err)
+
+	conn, mock := connection.CreateMockSQL(t)
+	defer conn.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(startTimeQuery)).
+		WillReturnRows(sqlmock.NewRows([]string{"sqlserver_start_time"}).AddRow(startTime))
+
+	s, err := Open(conn, e, dir, false)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	return s
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	started := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	lastEvent := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+
+	s := openTestState(t, dir, started)
+	assert.False(t, s.Restarted())
+	s.SetWatermark("events.lastTime", lastEvent)
+	s.SetIDWatermark("history.lastID", 42)
+	s.SetCounters("waits", testCounters{Reads: 10, Writes: 5})
+	assert.NoError(t, s.Save())
+
+	files, err := filepath.Glob(filepath.Join(dir, "com.newrelic.mssql-testhost-ms-instance_SQLSERVER01.json"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	// the state is read by the next run
+	s = openTestState(t, dir, started)
+	assert.False(t, s.Restarted())
+	watermark, ok := s.Watermark("events.lastTime")
+	assert.True(t, ok)
+	assert.True(t, lastEvent.Equal(watermark))
+	id, ok := s.IDWatermark("history.lastID")
+	assert.True(t, ok)
+	assert.Equal(t, int64(42), id)
+	var counters testCounters
+	ok = s.Counters("waits", &counters)
+	assert.True(t, ok)
+	assert.Equal(t, testCounters{Reads: 10, Writes: 5}, counters)
+
+	// counters are discarded once the instance restarts, watermarks are kept
+	s = openTestState(t, dir, started.Add(time.Hour))
+	assert.True(t, s.Restarted())
+	ok = s.Counters("waits", &counters)
+	assert.False(t, ok)
+	_, ok = s.Watermark("events.lastTime")
+	assert.True(t, ok)
+}
+
+func TestOpen_RemovesStaleFiles(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "com.newrelic.mssql-oldhost-ms-instance_OLD.json")
+	recent := filepath.Join(dir, "com.newrelic.mssql-otherhost-ms-instance_OTHER.json")
+	other := filepath.Join(dir, "com.newrelic.mssql.json")
+	for _, file := range []string{stale, recent, other} {
+		assert.NoError(t, os.WriteFile(file, []byte("{}"), 0644))
+	}
+	old := time.Now().Add(-TTL - time.Hour)
+	assert.NoError(t, os.Chtimes(stale, old, old))
+	assert.NoError(t, os.Chtimes(other, old, old))
+
+	openTestState(t, dir, time.Now())
+
+	assert.NoFileExists(t, stale)
+	assert.FileExists(t, recent)
+	// the store of the SDK is not a state file
+	assert.FileExists(t, other)
+}
+
+func TestState_EventIDs(t *testing.T) {
+	s := New(persist.NewInMemoryStore(), time.Time{})
+	assert.Nil(t, s.EventIDs("events"))
+
+	s.SetEventIDs("events", []string{"a1", "b2"})
+	assert.Equal(t, []string{"a1", "b2"}, s.EventIDs("events"))
+}
+
+func TestState_UnknownStartTime(t *testing.T) {
+	store := persist.NewInMemoryStore()
+	store.Set(startTimeKey, time.Now().Unix())
+
+	s := New(store, time.Time{})
+	assert.False(t, s.Restarted())
+
+	s.SetCounters("waits", testCounters{Reads: 1})
+	var counters testCounters
+	ok := s.Counters("waits", &counters)
+	assert.True(t, ok)
+}
+
+func TestCounterDelta(t *testing.T) {
+	delta, ok := CounterDelta(10, 25)
+	assert.True(t, ok)
+	assert.Equal(t, int64(15), delta)
+
+	_, ok = CounterDelta(25, 10)
+	assert.False(t, ok)
+}
@@ -4,6 +4,7 @@ package tests
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -48,7 +49,7 @@ func waitForMSSQLIsUpAndRunning(maxTries int) bool {
 		time.Sleep(5 * time.Second)
 		log.Info("try to establish de connection with the mssql database...")
 
-		conn, err := connection.NewConnection(&args.ArgumentList{
+		conn, err := connection.NewConnection(context.Background(), &args.ArgumentList{
 			Username: dbUsername,
 			Password: dbPassword,
 			Hostname: "localhost",